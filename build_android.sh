@@ -16,7 +16,12 @@ else
     PLATFORM="unknown"
 fi
 # shellcheck disable=SC2016
-buildCommand='go build -ldflags "-s -w" -buildmode=c-shared -o "$output_dir/libproxy.so" cli.go'
+# Set WITH_METRICS=1 to include the StartMetrics export.
+buildTags="nometrics"
+if [ "$WITH_METRICS" = "1" ]; then
+    buildTags=""
+fi
+buildCommand='go build -tags "'"$buildTags"'" -ldflags "-s -w" -buildmode=c-shared -o "$output_dir/libproxy.so" .'
 echo "$buildCommand"
 
 # For ARM64
@@ -19,7 +19,12 @@ build() {
     output_dir="./build/${sdk}/arm64"
     rm -rf "$output_dir"
     mkdir -p "$output_dir"
-    go build -buildmode=c-archive -o "$output_dir/proxy.a" cli.go
+    # Set WITH_METRICS=1 to include the StartMetrics export.
+    build_tags="nometrics"
+    if [ "$WITH_METRICS" = "1" ]; then
+        build_tags=""
+    fi
+    go build -tags "$build_tags" -buildmode=c-archive -o "$output_dir/proxy.a" .
 }
 
 # Build for Apple TVOS
@@ -0,0 +1,327 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthHeader carries the auth token of web socket upgrade requests when AuthInHeader is used.
+const AuthHeader = "Wstunnel-Auth"
+
+// AuthSubprotocolPrefix is followed by the auth token in Sec-WebSocket-Protocol when AuthInProtocol is used.
+const AuthSubprotocolPrefix = "wstunnel.auth."
+
+// Auth token transports, AuthInProtocol suits clients and CDNs that can only set the web socket subprotocol.
+const (
+	AuthInHeader   = "header"
+	AuthInProtocol = "protocol"
+)
+
+// DefaultAuthMaxAge is how far token timestamp may be from the verifier clock.
+const DefaultAuthMaxAge = time.Second * 30
+
+const (
+	authHMAC    = "hs256"
+	authEd25519 = "ed25519"
+)
+
+// Auth errors returned by TokenAuth.Verify.
+var (
+	ErrAuthMissing  = errors.New("auth token missing")
+	ErrAuthInvalid  = errors.New("auth token invalid")
+	ErrAuthExpired  = errors.New("auth token expired")
+	ErrAuthReplayed = errors.New("auth token replayed")
+)
+
+// TokenAuth
+// signs and verifies short lived tokens over a timestamp, random nonce, the server host name and the tunnel request path.
+// Tokens are HMAC-SHA256 with a shared secret or Ed25519, where clients hold the private key and servers the public key.
+// Verifiers remember nonces until tokens expire so a captured token can not be used twice.
+// //////////////////////////////////////////////////////////////////////////////
+type TokenAuth struct {
+	algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	maxAge     time.Duration
+	now        func() time.Time
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	lastPrune  time.Time
+}
+
+// NewHMACAuth creates signer and verifier sharing secret, which must be at least 16 bytes.
+func NewHMACAuth(secret []byte) (*TokenAuth, error) {
+	if len(secret) < 16 {
+		return nil, errors.New("auth secret must be at least 16 bytes")
+	}
+	return newTokenAuth(authHMAC, &TokenAuth{secret: secret}), nil
+}
+
+// NewEd25519Auth creates signer from private key or verifier only from public key.
+func NewEd25519Auth(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) (*TokenAuth, error) {
+	if privateKey != nil {
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 key")
+	}
+	return newTokenAuth(authEd25519, &TokenAuth{privateKey: privateKey, publicKey: publicKey}), nil
+}
+
+// LoadAuthKeyFile reads PEM encoded Ed25519 PKCS#8 private key or PKIX public key.
+func LoadAuthKeyFile(path string) (*TokenAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in %s", path)
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if privateKey, ok := key.(ed25519.PrivateKey); ok {
+			return NewEd25519Auth(privateKey, nil)
+		}
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey, ok := key.(ed25519.PublicKey); ok {
+			return NewEd25519Auth(nil, publicKey)
+		}
+	}
+	return nil, fmt.Errorf("%s must contain Ed25519 PRIVATE KEY or PUBLIC KEY", path)
+}
+
+func newTokenAuth(algorithm string, a *TokenAuth) *TokenAuth {
+	a.algorithm = algorithm
+	a.maxAge = DefaultAuthMaxAge
+	a.now = time.Now
+	a.seen = make(map[string]time.Time)
+	return a
+}
+
+// canSign reports whether tokens can be created, Ed25519 verifiers only hold the public key.
+func (a *TokenAuth) canSign() bool {
+	return a.algorithm == authHMAC || a.privateKey != nil
+}
+
+// Token creates token for request path on host, every call uses a new nonce.
+func (a *TokenAuth) Token(host string, path string) (string, error) {
+	if !a.canSign() {
+		return "", errors.New("auth key can only verify tokens")
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(a.now().Unix(), 10)
+	encodedNonce := base64.RawURLEncoding.EncodeToString(nonce)
+	message := tokenMessage(a.algorithm, timestamp, encodedNonce, host, path)
+	var signature []byte
+	if a.algorithm == authHMAC {
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(message)
+		signature = mac.Sum(nil)
+	} else {
+		signature = ed25519.Sign(a.privateKey, message)
+	}
+	return strings.Join([]string{a.algorithm, timestamp, encodedNonce, base64.RawURLEncoding.EncodeToString(signature)}, "."), nil
+}
+
+// tokenMessage is the signed content, host and path bind token to the server and the tunnel target.
+func tokenMessage(algorithm string, timestamp string, nonce string, host string, path string) []byte {
+	return []byte("wstunnel-auth-v2\n" + algorithm + "\n" + timestamp + "\n" + nonce + "\n" + authHost(host) + "\n" + path)
+}
+
+// authHost is the lower case host name without port, proxies in front of the server may change the port.
+func authHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// Verify checks token signed for path on host, is within max age and was not used before.
+func (a *TokenAuth) Verify(token string, host string, path string) error {
+	if token == "" {
+		return ErrAuthMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != a.algorithm {
+		return ErrAuthInvalid
+	}
+	// Strict decoding rejects other spellings of the same signature, the nonce is covered by the signature.
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[3])
+	if err != nil {
+		return ErrAuthInvalid
+	}
+	message := tokenMessage(parts[0], parts[1], parts[2], host, path)
+	if a.algorithm == authHMAC {
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(message)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrAuthInvalid
+		}
+	} else if !ed25519.Verify(a.publicKey, message, signature) {
+		return ErrAuthInvalid
+	}
+	timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrAuthInvalid
+	}
+	now := a.now()
+	issued := time.Unix(timestamp, 0)
+	if issued.Before(now.Add(-a.maxAge)) || issued.After(now.Add(a.maxAge)) {
+		return ErrAuthExpired
+	}
+	return a.remember(parts[2], issued.Add(a.maxAge), now)
+}
+
+// remember records nonce until expiry, tokens are rejected by their timestamp afterwards.
+func (a *TokenAuth) remember(nonce string, expiry time.Time, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastPrune) > time.Second {
+		for seen, until := range a.seen {
+			if now.After(until) {
+				delete(a.seen, seen)
+			}
+		}
+		a.lastPrune = now
+	}
+	if _, ok := a.seen[nonce]; ok {
+		return ErrAuthReplayed
+	}
+	a.seen[nonce] = expiry
+	return nil
+}
+
+// VerifyRequest checks token of web socket upgrade request from AuthHeader or Sec-WebSocket-Protocol against
+// the request Host and path.
+func (a *TokenAuth) VerifyRequest(r *http.Request) error {
+	token := r.Header.Get(AuthHeader)
+	if token == "" {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if strings.HasPrefix(protocol, AuthSubprotocolPrefix) {
+				token = strings.TrimPrefix(protocol, AuthSubprotocolPrefix)
+				break
+			}
+		}
+	}
+	return a.Verify(token, r.Host, r.URL.Path)
+}
+
+// CheckRequest has websocket.Upgrader CheckOrigin signature, failed checks are logged.
+func (a *TokenAuth) CheckRequest(r *http.Request) bool {
+	if err := a.VerifyRequest(r); err != nil {
+		Logger.Errorf("%s - Unauthorized %s: %s", r.RemoteAddr, r.URL.Path, err)
+		return false
+	}
+	return true
+}
+
+// WithAuth signs web socket upgrade requests with auth, sent as AuthInHeader or AuthInProtocol.
+// Stunnel remotes have no http handshake and are not signed.
+func WithAuth(auth *TokenAuth, transport string) ClientOption {
+	return func(h *httpClient) {
+		h.auth = auth
+		h.authTransport = transport
+	}
+}
+
+// prepareAuth checks auth can sign and transport is known.
+func (h *httpClient) prepareAuth() error {
+	if h.auth == nil {
+		return nil
+	}
+	if !h.auth.canSign() {
+		return errors.New("auth needs HMAC secret or Ed25519 private key to sign tokens")
+	}
+	if h.authTransport == "" {
+		h.authTransport = AuthInHeader
+	}
+	if h.authTransport != AuthInHeader && h.authTransport != AuthInProtocol {
+		return fmt.Errorf("auth transport must be %s or %s, got %q", AuthInHeader, AuthInProtocol, h.authTransport)
+	}
+	for _, endpoint := range h.remotes.endpoints {
+		if endpoint.tunnelType == Stunnel {
+			Logger.Warnf("Auth token is not sent to Stunnel remote %s, it has no http handshake", endpoint.url)
+		}
+	}
+	return nil
+}
+
+// signRequest adds a fresh token for wsURL to headers or dialer subprotocols. Token is signed for the Host header
+// when headers replace it, every redirect hop gets a token for its own host.
+func (h *httpClient) signRequest(dialer *websocket.Dialer, headers http.Header, wsURL string) (http.Header, error) {
+	if h.auth == nil {
+		return headers, nil
+	}
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if headers.Get("Host") != "" {
+		host = headers.Get("Host")
+	}
+	token, err := h.auth.Token(host, u.Path)
+	if err != nil {
+		return nil, err
+	}
+	if h.authTransport == AuthInProtocol {
+		dialer.Subprotocols = append(dialer.Subprotocols, AuthSubprotocolPrefix+token)
+		return headers, nil
+	}
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set(AuthHeader, token)
+	return headers, nil
+}
+
+// WithRequiredAuth rejects tunnel requests without valid token before their target is dialed.
+func WithRequiredAuth(auth *TokenAuth) ServerOption {
+	return func(s *wsTunnelServer) {
+		s.auth = auth
+	}
+}
+
+// authenticate wraps handler with auth check when auth is required.
+func (s *wsTunnelServer) authenticate(handler http.Handler) http.Handler {
+	if s.auth == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.CheckRequest(r) {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func TestTokenAuth(t *testing.T) {
+	auth, err := NewHMACAuth([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.Token("wstunnel.example.com", "/tcp/127.0.0.1/1194")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Verify(token, "wstunnel.example.com", "/tcp/127.0.0.1/22"); !errors.Is(err, ErrAuthInvalid) {
+		t.Errorf("token for other target should be invalid, got %v", err)
+	}
+	if err = auth.Verify(token, "other.example.com", "/tcp/127.0.0.1/1194"); !errors.Is(err, ErrAuthInvalid) {
+		t.Errorf("token for other host should be invalid, got %v", err)
+	}
+	if err = auth.Verify(token, "WSTunnel.example.com:443", "/tcp/127.0.0.1/1194"); err != nil {
+		t.Errorf("host should match without case and port, got %v", err)
+	}
+	if err = auth.Verify(token, "wstunnel.example.com", "/tcp/127.0.0.1/1194"); !errors.Is(err, ErrAuthReplayed) {
+		t.Errorf("second use should be replay, got %v", err)
+	}
+	// Unused low bits of the last base64 character must not make a new token.
+	last := strings.IndexByte(base64URLAlphabet, token[len(token)-1])
+	respelled := token[:len(token)-1] + string(base64URLAlphabet[last^1])
+	if err = auth.Verify(respelled, "wstunnel.example.com", "/tcp/127.0.0.1/1194"); err == nil {
+		t.Error("respelled signature should not be accepted again")
+	}
+	auth.now = func() time.Time { return time.Now().Add(-time.Minute) }
+	old, _ := auth.Token("wstunnel.example.com", "/tcp/127.0.0.1/1194")
+	auth.now = time.Now
+	if err = auth.Verify(old, "wstunnel.example.com", "/tcp/127.0.0.1/1194"); !errors.Is(err, ErrAuthExpired) {
+		t.Errorf("old token should be expired, got %v", err)
+	}
+	if _, err = NewHMACAuth([]byte("short")); err == nil {
+		t.Error("short secret should be rejected")
+	}
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := NewEd25519Auth(privateKey, nil)
+	verifier, err := NewEd25519Auth(nil, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.Token("wstunnel.example.com", "/tcp/127.0.0.1/1194"); err == nil {
+		t.Error("public key should not sign")
+	}
+	token, _ = signer.Token("wstunnel.example.com", "/tcp/127.0.0.1/1194")
+	if err = verifier.Verify(token, "wstunnel.example.com", "/tcp/127.0.0.1/1194"); err != nil {
+		t.Error(err)
+	}
+	hmacToken, _ := NewHMACAuth([]byte("0123456789abcdef"))
+	token, _ = hmacToken.Token("wstunnel.example.com", "/tcp/127.0.0.1/1194")
+	if err = verifier.Verify(token, "wstunnel.example.com", "/tcp/127.0.0.1/1194"); !errors.Is(err, ErrAuthInvalid) {
+		t.Errorf("HMAC token should be invalid for Ed25519 verifier, got %v", err)
+	}
+}
+
+func TestAuthHandshake(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7014")
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	verifier, _ := NewEd25519Auth(nil, publicKey)
+	signer, _ := NewEd25519Auth(privateKey, nil)
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8093", "", "", 1600, WithRequiredAuth(verifier)).Run()
+	}()
+	remote := "ws://127.0.0.1:8093/tcp/127.0.0.1/7014"
+	clients := []struct {
+		listen  string
+		options []ClientOption
+		allowed bool
+	}{
+		{"127.0.0.1:1213", []ClientOption{WithAuth(signer, AuthInHeader)}, true},
+		{"127.0.0.1:1214", []ClientOption{WithAuth(signer, AuthInProtocol), WithMultiplexing(true)}, true},
+		{"127.0.0.1:1215", nil, false},
+	}
+	for _, c := range clients {
+		controller := NewController()
+		defer func() { _, _ = controller.Stop(time.Second) }()
+		client := NewHTTPClient(c.listen, remote, WSTunnel, 1600, func(fd int) {}, controller, false, "", c.options...)
+		go func() {
+			_ = client.Run()
+		}()
+	}
+	time.Sleep(time.Millisecond * 200)
+	for _, c := range clients {
+		conn, err := net.Dial("tcp", c.listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 2))
+		_, _ = conn.Write([]byte("ping"))
+		received := make([]byte, 4)
+		_, err = io.ReadFull(conn, received)
+		if c.allowed && (err != nil || string(received) != "ping") {
+			t.Errorf("%s: echo mismatch: %q %v", c.listen, received, err)
+		} else if !c.allowed && err == nil {
+			t.Errorf("%s: unauthorized client should not reach target", c.listen)
+		}
+		_ = conn.Close()
+	}
+}
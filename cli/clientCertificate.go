@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	tls "github.com/refraction-networking/utls"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadClientCertificate loads client certificate chain and private key from PEM files.
+func LoadClientCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// LoadClientCertificatePKCS12 decodes PKCS#12 blob containing the client certificate, its chain and private key.
+func LoadClientCertificatePKCS12(data []byte, password string) (*tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, err
+	}
+	var key crypto.Signer
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY":
+			key, err = parsePKCS12Key(block)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if key == nil {
+		return nil, errors.New("pkcs12: no private key found")
+	}
+	// Leaf must come first, pkcs12 does not define order of the bags.
+	publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return nil, errors.New("pkcs12: unsupported private key type")
+	}
+	cert := &tls.Certificate{PrivateKey: key}
+	for _, c := range certs {
+		if publicKey.Equal(c.PublicKey) {
+			cert.Certificate = append([][]byte{c.Raw}, cert.Certificate...)
+			cert.Leaf = c
+		} else {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+	}
+	if cert.Leaf == nil {
+		return nil, errors.New("pkcs12: no certificate matches private key")
+	}
+	return cert, nil
+}
+
+// parsePKCS12Key parses key blocks from pkcs12.ToPEM, they are PKCS #1 for RSA and SEC 1 for ECDSA.
+func parsePKCS12Key(block *pem.Block) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("pkcs12: unsupported private key encoding")
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"crypto/ecdsa"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes cert and key as PEM files to dir and returns their paths.
+func writeCertificate(t *testing.T, dir string, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issueCertificate(t, "ca.example.com", true, nil, nil)
+	client, clientKey := issueCertificate(t, "client.example.com", false, ca, caKey)
+	certFile, keyFile := writeCertificate(t, dir, "client", client, clientKey)
+	cert, err := LoadClientCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 1 || string(cert.Certificate[0]) != string(client.Raw) {
+		t.Error("loaded certificate should be the client certificate")
+	}
+	other, otherKey := issueCertificate(t, "other.example.com", false, nil, nil)
+	_, otherKeyFile := writeCertificate(t, dir, "other", other, otherKey)
+	if _, err = LoadClientCertificate(certFile, otherKeyFile); err == nil {
+		t.Error("key of other certificate should be rejected")
+	}
+	if _, err = LoadClientCertificate(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("missing file should be rejected")
+	}
+}
+
+func TestLoadClientCertificatePKCS12(t *testing.T) {
+	data, err := os.ReadFile("testdata/client.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadClientCertificatePKCS12(data, "wstunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "wstunnel test client" {
+		t.Errorf("client certificate should be the leaf, got %v", cert.Leaf)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Errorf("leaf and CA should be in the chain, got %d certificates", len(cert.Certificate))
+	}
+	if _, err = LoadClientCertificatePKCS12(data, "wrong"); err == nil {
+		t.Error("wrong password should be rejected")
+	}
+	if _, err = LoadClientCertificatePKCS12([]byte("not pkcs12"), "wstunnel"); err == nil {
+		t.Error("invalid data should be rejected")
+	}
+}
+
+func TestClientCertificateHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issueCertificate(t, "ca.example.com", true, nil, nil)
+	client, clientKey := issueCertificate(t, "client.example.com", false, ca, caKey)
+	certFile, keyFile := writeCertificate(t, dir, "client", client, clientKey)
+	pemCert, err := LoadClientCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12Data, _ := os.ReadFile("testdata/client.p12")
+	p12Cert, err := LoadClientCertificatePKCS12(p12Data, "wstunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted, untrustedKey := issueCertificate(t, "client.example.com", false, nil, nil)
+	untrustedFile, untrustedKeyFile := writeCertificate(t, dir, "untrusted", untrusted, untrustedKey)
+	untrustedCert, _ := LoadClientCertificate(untrustedFile, untrustedKeyFile)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	fixtureCA, _ := os.ReadFile("testdata/ca.pem")
+	clientCAs.AppendCertsFromPEM(fixtureCA)
+	serverCert, err := generateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := stdtls.Listen("tcp", "127.0.0.1:0", &stdtls.Config{
+		Certificates: []stdtls.Certificate{serverCert},
+		ClientAuth:   stdtls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	results := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+			results <- conn.(*stdtls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	remote := "https://" + listener.Addr().String()
+	cases := []struct {
+		name    string
+		options []ClientOption
+		allowed bool
+	}{
+		{"PEM", []ClientOption{WithClientCertificate(pemCert)}, true},
+		{"PKCS#12", []ClientOption{WithClientCertificate(p12Cert)}, true},
+		{"untrusted", []ClientOption{WithClientCertificate(untrustedCert)}, false},
+		{"none", nil, false},
+	}
+	for _, c := range cases {
+		h := NewHTTPClient("", remote, Stunnel, 1600, func(fd int) {}, NewController(), false, "", c.options...).(*httpClient)
+		conn, _ := h.dialStunnel(context.Background(), remote, "", "")
+		if err := <-results; (err == nil) != c.allowed {
+			t.Errorf("%s client certificate: allowed %t, server handshake got %v", c.name, c.allowed, err)
+		}
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+}
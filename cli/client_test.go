@@ -1,28 +1,41 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"testing"
 	"time"
 )
 
 var protocol = "ws://"
-var echoServerAddress = "localhost:8080"
-var path = "/ws"
-var webSocketServerAddress = fmt.Sprintf("%s%s%s", protocol, echoServerAddress, path)
+var tunnelServerAddress = "localhost:8080"
+var targetServerAddress = "127.0.0.1:7001"
+var webSocketServerAddress = fmt.Sprintf("%s%s%s%s", protocol, tunnelServerAddress, TcpPathPrefix, "127.0.0.1/7001")
 var tcpServerAddress = "localhost:1194"
 var dataToSend = []byte("Send me this message back.")
 
-func TestEndToEndConnection(t *testing.T) {
+// TestMain initialises the logger once, servers started by earlier tests keep logging while later tests run.
+func TestMain(m *testing.M) {
 	InitLogger(true, "")
+	os.Exit(m.Run())
+}
+
+func TestEndToEndConnection(t *testing.T) {
+	//Tcp target
+	startTcpEchoServer(t, targetServerAddress)
 	//Ws server
-	startServer(echoServerAddress, path)
+	go func() {
+		_ = NewWsTunnelServer(tunnelServerAddress, "", "", 1600).Run()
+	}()
 	//Tcp server
+	controller := NewController()
 	go func() {
 		err := NewHTTPClient(tcpServerAddress, webSocketServerAddress, 1, 1600, func(fd int) {
 			t.Log(fd)
-		}, Channel, false).Run()
+		}, controller, false, "").Run()
 		if err != nil {
 			t.Fail()
 			return
@@ -30,20 +43,22 @@ func TestEndToEndConnection(t *testing.T) {
 	}()
 	time.Sleep(time.Millisecond * 100)
 	//Client 1
-	_, client1Err := mockClientConnection()
-	if client1Err != nil {
+	data, client1Err := mockClientConnection()
+	if client1Err != nil || data != string(dataToSend) {
 		t.Fail()
 		return
 	}
 	//Client 2
-	_, client2Err := mockClientConnection()
-	if client2Err != nil {
+	data, client2Err := mockClientConnection()
+	if client2Err != nil || data != string(dataToSend) {
 		t.Fail()
 		return
 	}
 	//Exit
 	time.Sleep(time.Millisecond * 100)
-	Channel <- "done"
+	if _, err := controller.Stop(time.Second); err != nil {
+		t.Error(err)
+	}
 	//Client 3
 	_, client3Err := mockClientConnection()
 	if client3Err == nil {
@@ -53,17 +68,91 @@ func TestEndToEndConnection(t *testing.T) {
 	t.Log("Test is successful.")
 }
 
+func TestStunnelConnection(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7002")
+	go func() {
+		_ = NewStunnelServer("127.0.0.1:8443", "127.0.0.1:7002", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	client := NewHTTPClient("127.0.0.1:1195", "https://127.0.0.1:8443", Stunnel, 1600, func(fd int) {}, controller, false, "")
+	go func() {
+		_ = client.Run()
+	}()
+	time.Sleep(time.Millisecond * 300)
+	// Randomized ClientHello differs on every dial, each one must complete with the crypto/tls server.
+	for i := 0; i < 100; i++ {
+		tlsConn, err := client.(*httpClient).dialStunnel(context.Background(), "https://127.0.0.1:8443", "", "")
+		if err != nil {
+			t.Fatalf("handshake %d: %s", i, err)
+		}
+		_ = tlsConn.Close()
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:1195")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(dataToSend); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len(dataToSend))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = io.ReadFull(conn, data); err != nil || string(data) != string(dataToSend) {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	_, _ = controller.Stop(time.Second)
+}
+
+func TestParseTargetPath(t *testing.T) {
+	cases := map[string]string{
+		"/tcp/127.0.0.1/1194": "127.0.0.1:1194",
+		"/tcp/[::1]/443/":     "[::1]:443",
+		"/tcp/example.com/80": "example.com:80",
+	}
+	for path, expected := range cases {
+		target, err := parseTargetPath(path, TcpPathPrefix)
+		if err != nil || target != expected {
+			t.Errorf("%s > got %s, %v want %s", path, target, err, expected)
+		}
+	}
+	for _, path := range []string{"/tcp/", "/tcp/host", "/tcp/host/port", "/udp/host/80", "/tcp/host/70000"} {
+		if _, err := parseTargetPath(path, TcpPathPrefix); err == nil {
+			t.Errorf("%s > expected error", path)
+		}
+	}
+}
+
+func startTcpEchoServer(t *testing.T, address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
 func mockClientConnection() (string, error) {
 	var conn, connErr = net.Dial("tcp", tcpServerAddress)
 	if connErr != nil {
 		return "", connErr
 	}
+	defer conn.Close()
 	_, writeErr := conn.Write(dataToSend)
 	if writeErr != nil {
 		return "", writeErr
 	}
 	time.Sleep(time.Second * 1)
 	data := make([]byte, 30)
-	_, err := conn.Read(data)
-	return string(data), err
+	readSize, err := conn.Read(data)
+	return string(data[:readSize]), err
 }
@@ -0,0 +1,313 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config
+// describes proxy settings in a YAML or JSON document, JSON is read as YAML.
+// Durations are strings like "500ms" or "10s". Keys left out keep DefaultConfig values.
+// ConnectAddress and HostHeader apply to all remotes, see Fronting, their SNI is TLS.ServerName.
+// UpstreamProxy is a proxy url or DirectUpstream, empty uses proxy from environment.
+// //////////////////////////////////////////////////////////////////////////////
+type Config struct {
+	Listen         ListenConfig      `yaml:"listen"`
+	Remotes        []RemoteConfig    `yaml:"remotes"`
+	Headers        map[string]string `yaml:"headers"`
+	ConnectAddress string            `yaml:"connectAddress"`
+	HostHeader     string            `yaml:"hostHeader"`
+	UpstreamProxy  string            `yaml:"upstreamProxy"`
+	TunnelType     int               `yaml:"tunnelType"`
+	MTU            int               `yaml:"mtu"`
+	Multiplex      bool              `yaml:"multiplex"`
+	Failover       FailoverConfig    `yaml:"failover"`
+	Reconnect      ReconnectConfig   `yaml:"reconnect"`
+	TLS            TLSConfig         `yaml:"tls"`
+	Auth           AuthConfig        `yaml:"auth"`
+	Redirects      RedirectConfig    `yaml:"redirects"`
+	Timeouts       TimeoutConfig     `yaml:"timeouts"`
+	Logging        LoggingConfig     `yaml:"logging"`
+}
+
+// ListenConfig is the local listener and the protocol served on it.
+type ListenConfig struct {
+	Address       string `yaml:"address"`
+	SOCKS5        bool   `yaml:"socks5"`
+	SOCKSUsername string `yaml:"socksUsername"`
+	SOCKSPassword string `yaml:"socksPassword"`
+	HTTPProxy     bool   `yaml:"httpProxy"`
+	Reverse       bool   `yaml:"reverse"`
+}
+
+// RemoteConfig is a remote url, given as plain string or as mapping with url, headers and fronting settings.
+// Headers replace headers of the same name from Config.Headers for this remote.
+type RemoteConfig struct {
+	URL            string            `yaml:"url"`
+	Headers        map[string]string `yaml:"headers"`
+	ConnectAddress string            `yaml:"connectAddress"`
+	SNI            string            `yaml:"sni"`
+	HostHeader     string            `yaml:"hostHeader"`
+}
+
+// remoteConfigKeys are keys accepted in a remote mapping.
+var remoteConfigKeys = []string{"url", "headers", "connectAddress", "sni", "hostHeader"}
+
+func (r *RemoteConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&r.URL)
+	}
+	if value.Kind == yaml.MappingNode {
+		// Node.Decode does not reject unknown keys, check them here.
+		for i := 0; i < len(value.Content); i += 2 {
+			key := value.Content[i].Value
+			known := false
+			for _, k := range remoteConfigKeys {
+				known = known || key == k
+			}
+			if !known {
+				return fmt.Errorf("line %d: field %s not found in remote, expected one of %s", value.Content[i].Line, key, strings.Join(remoteConfigKeys, ", "))
+			}
+		}
+	}
+	type plain RemoteConfig
+	return value.Decode((*plain)(r))
+}
+
+// Fronting returns fronting settings of the remote.
+func (r RemoteConfig) Fronting() Fronting {
+	return Fronting{ConnectAddress: r.ConnectAddress, SNI: r.SNI, HostHeader: r.HostHeader}
+}
+
+// RemoteURLs returns urls of all remotes.
+func (c Config) RemoteURLs() []string {
+	urls := make([]string, 0, len(c.Remotes))
+	for _, remote := range c.Remotes {
+		urls = append(urls, remote.URL)
+	}
+	return urls
+}
+
+// FailoverConfig selects how multiple remotes are tried.
+type FailoverConfig struct {
+	Strategy          string        `yaml:"strategy"`
+	UnhealthyCooldown time.Duration `yaml:"unhealthyCooldown"`
+	RaceDelay         time.Duration `yaml:"raceDelay"`
+}
+
+// ReconnectConfig is ReconnectPolicy in configuration files.
+type ReconnectConfig struct {
+	InitialDelay time.Duration `yaml:"initialDelay"`
+	MaxDelay     time.Duration `yaml:"maxDelay"`
+	Jitter       float64       `yaml:"jitter"`
+	MaxAttempts  int           `yaml:"maxAttempts"`
+}
+
+// TLSConfig covers ClientHello and server verification, ClientPKCS12 is base64 encoded.
+type TLSConfig struct {
+	ServerName           string   `yaml:"serverName"`
+	ExtraPadding         bool     `yaml:"extraPadding"`
+	Fingerprint          string   `yaml:"fingerprint"`
+	PinSha256            []string `yaml:"pinSha256"`
+	CABundle             string   `yaml:"caBundle"`
+	ClientCert           string   `yaml:"clientCert"`
+	ClientKey            string   `yaml:"clientKey"`
+	ClientPKCS12         string   `yaml:"clientPKCS12"`
+	ClientPKCS12Password string   `yaml:"clientPKCS12Password"`
+}
+
+// AuthConfig signs web socket upgrades with HMAC Secret or Ed25519 private key in KeyFile, see TokenAuth.
+type AuthConfig struct {
+	Secret    string `yaml:"secret"`
+	KeyFile   string `yaml:"keyFile"`
+	Transport string `yaml:"transport"`
+}
+
+// RedirectConfig is RedirectPolicy in configuration files.
+type RedirectConfig struct {
+	MaxHops        int      `yaml:"maxHops"`
+	Schemes        []string `yaml:"schemes"`
+	SameHost       bool     `yaml:"sameHost"`
+	AllowDowngrade bool     `yaml:"allowDowngrade"`
+}
+
+// TimeoutConfig groups keepalive, idle and shutdown timers.
+type TimeoutConfig struct {
+	PingInterval  time.Duration `yaml:"pingInterval"`
+	PongTimeout   time.Duration `yaml:"pongTimeout"`
+	UDPIdle       time.Duration `yaml:"udpIdle"`
+	Drain         time.Duration `yaml:"drain"`
+	StatsInterval time.Duration `yaml:"statsInterval"`
+}
+
+// LoggingConfig is applied by Initialise when File is set.
+type LoggingConfig struct {
+	File string `yaml:"file"`
+	Dev  bool   `yaml:"dev"`
+}
+
+// DefaultConfig returns settings used for keys missing from configuration.
+func DefaultConfig() Config {
+	return Config{
+		Listen:     ListenConfig{Address: ":65479"},
+		Auth:       AuthConfig{Transport: AuthInHeader},
+		Redirects:  RedirectConfig{MaxHops: DefaultRedirectPolicy().MaxHops, Schemes: DefaultRedirectPolicy().Schemes},
+		TunnelType: WSTunnel,
+		MTU:        1500,
+		Failover:   FailoverConfig{Strategy: FailoverOrdered, UnhealthyCooldown: time.Second * 30},
+		Reconnect:  ReconnectConfig{InitialDelay: time.Millisecond * 500, MaxDelay: time.Second * 10, Jitter: 0.2, MaxAttempts: 1},
+		Timeouts:   TimeoutConfig{PongTimeout: time.Second * 10, UDPIdle: DefaultUDPIdleTimeout, Drain: time.Second * 10},
+	}
+}
+
+// LoadConfig reads and validates configuration file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("reading config: %w", err)
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig decodes YAML or JSON configuration over DefaultConfig and validates it.
+// Unknown keys are rejected so typos do not silently fall back to defaults.
+func ParseConfig(data []byte) (Config, error) {
+	config := DefaultConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate checks settings that can be checked without dialing or reading files, all problems are reported together.
+func (c Config) Validate() error {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if _, _, err := net.SplitHostPort(c.Listen.Address); err != nil {
+		invalid("listen.address %q must be host:port: %s", c.Listen.Address, err)
+	}
+	if c.Listen.SOCKS5 && c.Listen.HTTPProxy {
+		invalid("listen.socks5 and listen.httpProxy can not both be enabled")
+	}
+	if c.Listen.SOCKSPassword != "" && c.Listen.SOCKSUsername == "" {
+		invalid("listen.socksPassword requires listen.socksUsername")
+	}
+	if c.TunnelType != WSTunnel && c.TunnelType != Stunnel && c.TunnelType != UDPTunnel {
+		invalid("tunnelType must be %d (WStunnel), %d (Stunnel) or %d (UDP), got %d", WSTunnel, Stunnel, UDPTunnel, c.TunnelType)
+	}
+	if c.MTU < 1 || c.MTU > maxDatagramSize {
+		invalid("mtu must be between 1 and %d, got %d", maxDatagramSize, c.MTU)
+	}
+	if len(c.Remotes) == 0 {
+		invalid("remotes must list at least one remote")
+	} else if _, err := newRemotePool(strings.Join(c.RemoteURLs(), ","), c.TunnelType, c.Failover.Strategy, c.Failover.UnhealthyCooldown); err != nil {
+		invalid("remotes: %s", err)
+	}
+	if _, err := HeadersFromMap(c.Headers); err != nil {
+		invalid("headers: %s", err)
+	}
+	for i, remote := range c.Remotes {
+		if strings.TrimSpace(remote.URL) == "" || strings.Contains(remote.URL, ",") {
+			invalid("remotes[%d].url must be a single url, got %q", i, remote.URL)
+		}
+		if _, err := HeadersFromMap(remote.Headers); err != nil {
+			invalid("remotes[%d].headers: %s", i, err)
+		}
+		if err := remote.Fronting().Validate(); err != nil {
+			invalid("remotes[%d]: %s", i, err)
+		}
+	}
+	if err := (Fronting{ConnectAddress: c.ConnectAddress, HostHeader: c.HostHeader}).Validate(); err != nil {
+		invalid("%s", err)
+	}
+	if c.Reconnect.Jitter < 0 || c.Reconnect.Jitter > 1 {
+		invalid("reconnect.jitter must be between 0 and 1, got %g", c.Reconnect.Jitter)
+	}
+	if c.Reconnect.MaxAttempts > 1 && c.Reconnect.InitialDelay == 0 {
+		invalid("reconnect.initialDelay must be set when reconnect.maxAttempts is %d", c.Reconnect.MaxAttempts)
+	}
+	if c.Reconnect.MaxDelay > 0 && c.Reconnect.MaxDelay < c.Reconnect.InitialDelay {
+		invalid("reconnect.maxDelay %s is shorter than reconnect.initialDelay %s", c.Reconnect.MaxDelay, c.Reconnect.InitialDelay)
+	}
+	if (c.TLS.ClientCert == "") != (c.TLS.ClientKey == "") {
+		invalid("tls.clientCert and tls.clientKey must be set together")
+	}
+	if c.TLS.ClientCert != "" && c.TLS.ClientPKCS12 != "" {
+		invalid("tls.clientCert and tls.clientPKCS12 can not both be set")
+	}
+	if _, err := base64.StdEncoding.DecodeString(c.TLS.ClientPKCS12); err != nil {
+		invalid("tls.clientPKCS12 must be base64: %s", err)
+	}
+	if len(c.TLS.PinSha256) > 0 {
+		if _, err := NewPeerVerifier(c.TLS.PinSha256, ""); err != nil {
+			invalid("tls.pinSha256: %s", err)
+		}
+	}
+	if c.Auth.Secret != "" && c.Auth.KeyFile != "" {
+		invalid("auth.secret and auth.keyFile can not both be set")
+	}
+	if c.Auth.Secret != "" && len(c.Auth.Secret) < 16 {
+		invalid("auth.secret must be at least 16 bytes")
+	}
+	if c.Auth.Transport != AuthInHeader && c.Auth.Transport != AuthInProtocol {
+		invalid("auth.transport must be %s or %s, got %q", AuthInHeader, AuthInProtocol, c.Auth.Transport)
+	}
+	if c.UpstreamProxy != "" && c.UpstreamProxy != DirectUpstream {
+		if _, err := NewUpstreamProxy(c.UpstreamProxy); err != nil {
+			invalid("upstreamProxy: %s", err)
+		}
+	}
+	if c.Redirects.MaxHops < 0 {
+		invalid("redirects.maxHops must not be negative, got %d", c.Redirects.MaxHops)
+	}
+	for _, scheme := range c.Redirects.Schemes {
+		if scheme != "ws" && scheme != "wss" {
+			invalid("redirects.schemes may list ws and wss, got %q", scheme)
+		}
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"failover.unhealthyCooldown", c.Failover.UnhealthyCooldown},
+		{"failover.raceDelay", c.Failover.RaceDelay},
+		{"reconnect.initialDelay", c.Reconnect.InitialDelay},
+		{"reconnect.maxDelay", c.Reconnect.MaxDelay},
+		{"timeouts.pingInterval", c.Timeouts.PingInterval},
+		{"timeouts.pongTimeout", c.Timeouts.PongTimeout},
+		{"timeouts.udpIdle", c.Timeouts.UDPIdle},
+		{"timeouts.drain", c.Timeouts.Drain},
+		{"timeouts.statsInterval", c.Timeouts.StatsInterval},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			invalid("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+	if c.Timeouts.PingInterval > 0 && c.Timeouts.PongTimeout <= 0 {
+		invalid("timeouts.pongTimeout must be set when timeouts.pingInterval is enabled")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
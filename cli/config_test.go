@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigYAML(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+listen:
+  address: 127.0.0.1:1080
+  socks5: true
+remotes:
+  - wss://a.example.com/tcp/127.0.0.1/1194
+  - https://b.example.com
+  - url: wss://cdn.example.com/tcp/127.0.0.1/1194
+    headers:
+      Host: origin.example.com
+    connectAddress: 203.0.113.1:443
+    sni: front.example.com
+headers:
+  User-Agent: Mozilla/5.0
+failover:
+  strategy: lastGood
+timeouts:
+  pingInterval: 30s
+tls:
+  pinSha256: ["sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Listen.Address != "127.0.0.1:1080" || !config.Listen.SOCKS5 || len(config.Remotes) != 3 || config.Failover.Strategy != FailoverLastGood {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if config.Timeouts.PingInterval != time.Second*30 || config.Timeouts.PongTimeout != time.Second*10 || config.MTU != 1500 {
+		t.Errorf("missing keys should keep defaults %+v", config.Timeouts)
+	}
+	if remote := config.Remotes[2]; remote.URL != "wss://cdn.example.com/tcp/127.0.0.1/1194" || remote.Headers["Host"] != "origin.example.com" || config.Headers["User-Agent"] != "Mozilla/5.0" {
+		t.Errorf("unexpected headers %+v %+v", config.Remotes, config.Headers)
+	}
+	if fronting := config.Remotes[2].Fronting(); fronting != (Fronting{ConnectAddress: "203.0.113.1:443", SNI: "front.example.com"}) {
+		t.Errorf("unexpected fronting %+v", fronting)
+	}
+}
+
+func TestParseConfigJSON(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"remotes": ["ws://127.0.0.1:8080/udp/127.0.0.1/53"], "tunnelType": 3, "timeouts": {"udpIdle": "5s"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TunnelType != UDPTunnel || config.Timeouts.UDPIdle != time.Second*5 || config.Listen.Address != ":65479" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected []string
+	}{
+		{``, []string{"remotes must list at least one remote"}},
+		{`{"remote": ["ws://a"]}`, []string{"field remote not found"}},
+		{`{"remotes": ["ws://a"], "mtu": "big"}`, []string{"cannot unmarshal"}},
+		{`{"remotes": ["ws://a"], "timeouts": {"drain": "soon"}}`, []string{"`soon` into time.Duration"}},
+		{`{"remotes": ["ws://a"], "listen": {"address": "1080", "socks5": true, "httpProxy": true}}`, []string{"listen.address", "listen.socks5 and listen.httpProxy"}},
+		{`{"remotes": ["ws://a"], "failover": {"strategy": "random"}, "tls": {"clientCert": "c.pem"}}`, []string{"invalid failover strategy", "tls.clientCert and tls.clientKey"}},
+		{`{"remotes": ["ws://a"], "reconnect": {"initialDelay": "1m", "maxDelay": "1s"}, "timeouts": {"pingInterval": "-1s"}}`, []string{"reconnect.maxDelay", "timeouts.pingInterval must not be negative"}},
+		{`{"remotes": ["ws://a"], "reconnect": {"initialDelay": "0s", "maxAttempts": 3}}`, []string{"reconnect.initialDelay must be set"}},
+		{`{"remotes": [{"url": "ws://a", "header": {}}]}`, []string{"field header not found in remote"}},
+		{`{"remotes": [{"url": "ws://a,ws://b"}], "headers": {"Connection": "close"}}`, []string{"remotes[0].url", "headers: header Connection"}},
+		{`{"remotes": ["ws://a"], "tls": {"pinSha256": ["nope"], "clientPKCS12": "%%"}}`, []string{"tls.pinSha256", "tls.clientPKCS12 must be base64"}},
+		{`{"remotes": [{"url": "ws://a", "connectAddress": "front"}], "hostHeader": "a b"}`, []string{"remotes[0]: connect address", "invalid host header"}},
+		{`{"remotes": ["ws://a"], "auth": {"secret": "short", "transport": "query"}}`, []string{"auth.secret must be at least", "auth.transport must be"}},
+		{`{"remotes": ["ws://a"], "redirects": {"maxHops": -1, "schemes": ["https"]}}`, []string{"redirects.maxHops", "redirects.schemes"}},
+		{`{"remotes": ["ws://a"], "upstreamProxy": "ftp://proxy"}`, []string{"upstreamProxy: upstream proxy"}},
+	}
+	for _, test := range tests {
+		_, err := ParseConfig([]byte(test.config))
+		if err == nil {
+			t.Errorf("%s: expected error", test.config)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: error %q does not mention %q", test.config, err, expected)
+			}
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wstunnel.yaml")
+	if err := os.WriteFile(path, []byte("remotes: [ws://a]\nmtu: 0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "mtu") {
+		t.Errorf("error should name file and field, got %v", err)
+	}
+	if _, err := LoadConfig(path + ".missing"); err == nil {
+		t.Error("missing file should fail")
+	}
+}
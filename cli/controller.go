@@ -0,0 +1,325 @@
+package cli
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStopTimeout is returned by Controller.Stop when the client did not stop within the timeout.
+var ErrStopTimeout = errors.New("client did not stop in time")
+
+// ClientState is lifecycle state of a client.
+type ClientState int
+
+const (
+	StateIdle ClientState = iota
+	StateRunning
+	StatePaused
+	StateStopping
+	StateStopped
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StatePaused:
+		return "paused"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "idle"
+	}
+}
+
+func (s ClientState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Event types published to subscribers.
+const (
+	EventStateChanged     = "stateChanged"
+	EventConnectionOpened = "connectionOpened"
+	EventConnectionClosed = "connectionClosed"
+	EventDialFailed       = "dialFailed"
+	EventReloaded         = "reloaded"
+)
+
+// ClientEvent is published to subscribers on state changes and connection events.
+type ClientEvent struct {
+	Type    string      `json:"type"`
+	State   ClientState `json:"state"`
+	Message string      `json:"message,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+// ClientStatus is a snapshot of client state.
+type ClientStatus struct {
+	State             ClientState `json:"state"`
+	ListenAddress     string      `json:"listenAddress"`
+	ActiveConnections int         `json:"activeConnections"`
+	LastError         string      `json:"lastError,omitempty"`
+}
+
+// subscriberBacklog is the number of events buffered per subscriber, slower subscribers miss events.
+const subscriberBacklog = 32
+
+// Controller
+// controls a single client, it is passed to NewHTTPClient and safe for concurrent use.
+// //////////////////////////////////////////////////////////////////////////////
+type Controller struct {
+	mu            sync.Mutex
+	state         ClientState
+	listenAddress string
+	active        int
+	lastError     error
+	started       bool
+	stopping      chan struct{}
+	stopOnce      sync.Once
+	deadline      time.Time
+	done          chan struct{}
+	summary       DrainSummary
+	stats         func() ClientStats
+	reload        reloadFunc
+	subscribers   map[int]chan ClientEvent
+	nextID        int
+}
+
+func NewController() *Controller {
+	return &Controller{
+		stopping:    make(chan struct{}),
+		done:        make(chan struct{}),
+		subscribers: make(map[int]chan ClientEvent),
+	}
+}
+
+// Stop stops accepting connections and gives open tunnels until timeout to finish, web socket tunnels
+// are closed with close handshake. Tunnels still open after timeout are force closed.
+// The summary reports tunnels that were open when Stop was called.
+func (c *Controller) Stop(timeout time.Duration) (DrainSummary, error) {
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		c.deadline = time.Now().Add(timeout)
+		c.changeState(StateStopping, "")
+		c.mu.Unlock()
+		close(c.stopping)
+	})
+	c.mu.Lock()
+	started := c.started
+	deadline := c.deadline
+	c.mu.Unlock()
+	if !started {
+		return DrainSummary{}, nil
+	}
+	select {
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.summary, nil
+	case <-time.After(time.Until(deadline) + forceCloseGrace*2):
+		return DrainSummary{}, ErrStopTimeout
+	}
+}
+
+// Pause rejects new connections until Resume, established tunnels are kept.
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StateRunning {
+		c.changeState(StatePaused, "")
+	}
+}
+
+// Resume accepts new connections again after Pause.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StatePaused {
+		c.changeState(StateRunning, "")
+	}
+}
+
+// Status returns current state of the client.
+func (c *Controller) Status() ClientStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := ClientStatus{
+		State:             c.state,
+		ListenAddress:     c.listenAddress,
+		ActiveConnections: c.active,
+	}
+	if c.lastError != nil {
+		status.LastError = c.lastError.Error()
+	}
+	return status
+}
+
+// Stats returns traffic and connection counters of the client, they stay available after it stopped.
+func (c *Controller) Stats() ClientStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+	if stats == nil {
+		return ClientStats{Connections: []ConnectionStats{}}
+	}
+	return stats()
+}
+
+// Reload applies settings to connections accepted from now on, established tunnels keep running with the
+// settings they started with. Parameters are those of NewHTTPClient, on error the previous settings stay in use.
+// A changed tcp listen address is bound before the old listener is closed, a changed udp listen address
+// before new flows move to it. Switching between tcp, udp and reverse forwarding requires a restart.
+func (c *Controller) Reload(listenTCP, remoteServer string, tunnelType int, mtu int, extraPadding bool, tlsServerName string, options ...ClientOption) error {
+	c.mu.Lock()
+	reload := c.reload
+	c.mu.Unlock()
+	if reload == nil {
+		return errors.New("client is not running")
+	}
+	if err := reload(listenTCP, remoteServer, tunnelType, mtu, extraPadding, tlsServerName, options); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listenAddress = listenTCP
+	c.publish(EventReloaded, listenTCP)
+	return nil
+}
+
+// Subscribe returns channel receiving client events and function cancelling the subscription.
+// The channel is closed once client stops or subscription is cancelled.
+func (c *Controller) Subscribe() (<-chan ClientEvent, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := make(chan ClientEvent, subscriberBacklog)
+	if c.state == StateStopped {
+		close(events)
+		return events, func() {}
+	}
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = events
+	return events, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.subscribers[id]; ok {
+			delete(c.subscribers, id)
+			close(events)
+		}
+	}
+}
+
+// Done is closed when the client returned from Run.
+func (c *Controller) Done() <-chan struct{} {
+	return c.done
+}
+
+// paused reports whether new connections should be rejected.
+func (c *Controller) paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == StatePaused
+}
+
+// start marks client running on listenAddress. A controller runs a single client once,
+// when it was stopped before the client started errStopped is returned.
+func (c *Controller) start(listenAddress string, stats func() ClientStats, reload reloadFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return errors.New("controller is already used by another client")
+	}
+	c.started = true
+	c.listenAddress = listenAddress
+	c.stats = stats
+	c.reload = reload
+	if c.state == StateStopping {
+		c.closeLocked("")
+		return errStopped
+	}
+	c.changeState(StateRunning, listenAddress)
+	return nil
+}
+
+// drainDeadline returns time until which open tunnels may drain, now when client was not stopped by Stop.
+func (c *Controller) drainDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deadline.IsZero() {
+		return time.Now()
+	}
+	return c.deadline
+}
+
+// finish marks client stopped and closes subscriptions.
+func (c *Controller) finish(err error, summary DrainSummary) {
+	c.stopOnce.Do(func() {
+		close(c.stopping)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	message := ""
+	if err != nil {
+		c.lastError = err
+		message = err.Error()
+	}
+	c.summary = summary
+	c.closeLocked(message)
+}
+
+// closeLocked must be called with mu held, it marks client stopped and closes subscriptions.
+func (c *Controller) closeLocked(message string) {
+	c.changeState(StateStopped, message)
+	for id, events := range c.subscribers {
+		delete(c.subscribers, id)
+		close(events)
+	}
+	close(c.done)
+}
+
+// connectionOpened counts a new local connection or udp flow.
+func (c *Controller) connectionOpened(remoteAddr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+	c.publish(EventConnectionOpened, remoteAddr)
+}
+
+// connectionClosed counts a finished local connection or udp flow.
+func (c *Controller) connectionClosed(remoteAddr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.publish(EventConnectionClosed, remoteAddr)
+}
+
+// dialFailed records the error of a failed dial attempt.
+func (c *Controller) dialFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastError = err
+	c.publish(EventDialFailed, err.Error())
+}
+
+// changeState must be called with mu held.
+func (c *Controller) changeState(state ClientState, message string) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	c.publish(EventStateChanged, message)
+}
+
+// publish must be called with mu held, events are dropped for subscribers with full backlog.
+func (c *Controller) publish(eventType string, message string) {
+	event := ClientEvent{Type: eventType, State: c.state, Message: message, Time: time.Now()}
+	for _, events := range c.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
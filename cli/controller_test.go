@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoOnce sends a message through proxy and reports whether it came back.
+func echoOnce(proxy string) bool {
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 2))
+	_, _ = conn.Write([]byte("ping"))
+	received := make([]byte, 4)
+	_, err = io.ReadFull(conn, received)
+	return err == nil && string(received) == "ping"
+}
+
+func TestController(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7009")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8089", "", "", 1600).Run()
+	}()
+	first, second := NewController(), NewController()
+	events, cancel := first.Subscribe()
+	defer cancel()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1203", "ws://127.0.0.1:8089/tcp/127.0.0.1/7009", WSTunnel, 1600, func(fd int) {}, first, false, "").Run()
+	}()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1204", "ws://127.0.0.1:8089/tcp/127.0.0.1/7009", WSTunnel, 1600, func(fd int) {}, second, false, "").Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	if status := first.Status(); status.State != StateRunning || status.ListenAddress != "127.0.0.1:1203" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if !echoOnce("127.0.0.1:1203") {
+		t.Error("running client should forward connections")
+	}
+
+	first.Pause()
+	if echoOnce("127.0.0.1:1203") {
+		t.Error("paused client should reject connections")
+	}
+	first.Resume()
+	if !echoOnce("127.0.0.1:1203") {
+		t.Error("resumed client should forward connections")
+	}
+
+	if _, err := first.Stop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Status().State != StateStopped {
+		t.Error("client should be stopped")
+	}
+	if !echoOnce("127.0.0.1:1204") {
+		t.Error("stopping one client should not affect another")
+	}
+	var states []string
+	opened := 0
+	for event := range events {
+		switch event.Type {
+		case EventStateChanged:
+			states = append(states, event.State.String())
+		case EventConnectionOpened:
+			opened++
+		}
+	}
+	if strings.Join(states, ",") != "running,paused,running,stopping,stopped" {
+		t.Errorf("unexpected state changes %v", states)
+	}
+	if opened != 2 {
+		t.Errorf("expected 2 opened connections, got %d", opened)
+	}
+	_, _ = second.Stop(time.Second)
+}
+
+func TestControllerStoppedBeforeRun(t *testing.T) {
+	controller := NewController()
+	if _, err := controller.Stop(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHTTPClient("127.0.0.1:1205", "ws://127.0.0.1:1", WSTunnel, 1600, func(fd int) {}, controller, false, "").Run(); err != errStopped {
+		t.Errorf("expected client to return right away, got %v", err)
+	}
+	select {
+	case <-controller.Done():
+	default:
+		t.Error("controller should be done")
+	}
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// forceCloseGrace is how long force closed bridges get to return before Run gives up on them.
+const forceCloseGrace = time.Second
+
+// DrainSummary reports tunnels that were still open when the client was stopped.
+type DrainSummary struct {
+	Connections   int   `json:"connections"`
+	ForceClosed   int   `json:"forceClosed"`
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// trackedBridge is a running bridge that can be drained on shutdown.
+type trackedBridge interface {
+	Runner
+	// shutdown stops reading local side and closes remote side gracefully, data in flight is still delivered.
+	shutdown()
+	close()
+	counters() *trafficCounters
+	// localAddr is address of the local peer.
+	localAddr() net.Addr
+}
+
+// openBridge is a tracked bridge and the time it started.
+type openBridge struct {
+	bridge trackedBridge
+	since  time.Time
+}
+
+// bridgeTracker
+// keeps running bridges so shutdown can drain them, bridges started after draining began are refused.
+// Traffic of finished bridges is kept in closed.
+type bridgeTracker struct {
+	mu       sync.Mutex
+	active   map[trackedBridge]time.Time
+	total    int64
+	closed   TrafficStats
+	draining bool
+	changed  chan struct{}
+}
+
+func newBridgeTracker() *bridgeTracker {
+	return &bridgeTracker{
+		active:  make(map[trackedBridge]time.Time),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// add starts tracking bridge, false when client is already draining.
+func (t *bridgeTracker) add(b trackedBridge) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.active[b] = time.Now()
+	t.total++
+	return true
+}
+
+func (t *bridgeTracker) remove(b trackedBridge) {
+	t.mu.Lock()
+	delete(t.active, b)
+	t.closed.add(b.counters().snapshot())
+	t.mu.Unlock()
+	notify(t.changed)
+}
+
+// stats returns traffic of finished bridges, number of bridges ever tracked and the open ones.
+func (t *bridgeTracker) stats() (TrafficStats, int64, []openBridge) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bridges := make([]openBridge, 0, len(t.active))
+	for b, since := range t.active {
+		bridges = append(bridges, openBridge{bridge: b, since: since})
+	}
+	return t.closed, t.total, bridges
+}
+
+// startDrain refuses new bridges and returns those running.
+func (t *bridgeTracker) startDrain() []trackedBridge {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+	return t.snapshot()
+}
+
+// snapshot must be called with mu held.
+func (t *bridgeTracker) snapshot() []trackedBridge {
+	bridges := make([]trackedBridge, 0, len(t.active))
+	for b := range t.active {
+		bridges = append(bridges, b)
+	}
+	return bridges
+}
+
+// remaining returns bridges still running.
+func (t *bridgeTracker) remaining() []trackedBridge {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// wait blocks until all bridges returned or deadline passed, reporting whether they did.
+func (t *bridgeTracker) wait(deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		if len(t.remaining()) == 0 {
+			return true
+		}
+		select {
+		case <-t.changed:
+		case <-timer.C:
+			return len(t.remaining()) == 0
+		}
+	}
+}
+
+// runBridge runs bridge until it returns, tracking it for draining.
+func (h *httpClient) runBridge(b Runner) {
+	tracked, ok := b.(trackedBridge)
+	if !ok {
+		_ = b.Run()
+		return
+	}
+	if !h.bridges.add(tracked) {
+		tracked.close()
+		return
+	}
+	defer h.bridges.remove(tracked)
+	_ = tracked.Run()
+}
+
+// drain shuts down running bridges gracefully, waits until deadline and force closes the rest.
+func (h *httpClient) drain(deadline time.Time) DrainSummary {
+	bridges := h.bridges.startDrain()
+	summary := DrainSummary{Connections: len(bridges)}
+	for _, b := range bridges {
+		b.shutdown()
+	}
+	if !h.bridges.wait(deadline) {
+		for _, b := range h.bridges.remaining() {
+			b.close()
+			summary.ForceClosed++
+		}
+		h.bridges.wait(time.Now().Add(forceCloseGrace))
+	}
+	h.closeMuxes()
+	for _, b := range bridges {
+		traffic := b.counters().snapshot()
+		summary.BytesSent += traffic.BytesSent
+		summary.BytesReceived += traffic.BytesReceived
+	}
+	if summary.Connections > 0 {
+		Logger.Infof("Drained %d connections, %d force closed, %d bytes sent, %d bytes received", summary.Connections, summary.ForceClosed, summary.BytesSent, summary.BytesReceived)
+	}
+	return summary
+}
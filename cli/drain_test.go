@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainClosesTunnelsGracefully(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7010")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8090", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1206", "ws://127.0.0.1:8090/tcp/127.0.0.1/7010", WSTunnel, 1600, func(fd int) {}, controller, false, "").Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("tcp", "127.0.0.1:1206")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	_, _ = conn.Write([]byte("drain"))
+	received := make([]byte, 5)
+	if _, err = io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := controller.Stop(time.Second * 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Connections != 1 || summary.ForceClosed != 0 || summary.BytesSent != 5 || summary.BytesReceived != 5 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if _, err = conn.Read(received); err == nil {
+		t.Error("local connection should be closed after drain")
+	}
+}
+
+func TestDrainForceClosesStuckTunnels(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// Server never reads, so the close handshake is never answered.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-release
+		_ = conn.Close()
+	}))
+	defer server.Close()
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1207", "ws"+strings.TrimPrefix(server.URL, "http")+"/tcp/127.0.0.1/1", WSTunnel, 1600, func(fd int) {}, controller, false, "").Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("tcp", "127.0.0.1:1207")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 200)
+	start := time.Now()
+	summary, err := controller.Stop(time.Millisecond * 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Connections != 1 || summary.ForceClosed != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*300 || elapsed > time.Second*2 {
+		t.Errorf("stop should wait for drain timeout, took %s", elapsed)
+	}
+}
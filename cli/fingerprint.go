@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	tls "github.com/refraction-networking/utls"
+	"os"
+	"slices"
+	"strings"
+)
+
+// fingerprintProfiles maps --tlsFingerprint names to uTLS ClientHello ids.
+var fingerprintProfiles = map[string]tls.ClientHelloID{
+	"chrome":           tls.HelloChrome_Auto,
+	"firefox":          tls.HelloFirefox_Auto,
+	"safari":           tls.HelloSafari_Auto,
+	"ios":              tls.HelloIOS_Auto,
+	"edge":             tls.HelloEdge_Auto,
+	"randomized":       tls.HelloRandomized,
+	"randomizedalpn":   tls.HelloRandomizedALPN,
+	"randomizednoalpn": tls.HelloRandomizedNoALPN,
+}
+
+// Fingerprint
+// selects the ClientHello sent by both tunnel types, either a browser profile or a JSON ClientHelloSpec file.
+type Fingerprint struct {
+	id       tls.ClientHelloID
+	specJSON []byte
+}
+
+// NewFingerprint resolves profile name or path to a JSON ClientHelloSpec file.
+// Returns nil fingerprint for empty profile so each tunnel type keeps its default.
+func NewFingerprint(profile string) (*Fingerprint, error) {
+	if profile == "" {
+		return nil, nil
+	}
+	if id, ok := fingerprintProfiles[strings.ToLower(profile)]; ok {
+		return &Fingerprint{id: id}, nil
+	}
+	data, err := os.ReadFile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("unknown tls fingerprint %q, expected one of chrome, firefox, safari, ios, edge, randomized or a JSON ClientHelloSpec file", profile)
+	}
+	f := &Fingerprint{specJSON: data}
+	if _, err = f.Spec(tls.HelloCustom); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Spec generates ClientHello spec for a new connection, randomized profiles differ on every call.
+// Nil fingerprint falls back to defaultID.
+func (f *Fingerprint) Spec(defaultID tls.ClientHelloID) (*tls.ClientHelloSpec, error) {
+	if f != nil && f.specJSON != nil {
+		// Extensions keep per connection state, so spec is decoded again every time.
+		var u tls.ClientHelloSpecJSONUnmarshaler
+		if err := json.Unmarshal(f.specJSON, &u); err != nil {
+			return nil, fmt.Errorf("invalid ClientHelloSpec JSON: %w", err)
+		}
+		if u.CipherSuites == nil || u.CompressionMethods == nil || u.Extensions == nil {
+			return nil, errors.New("invalid ClientHelloSpec JSON: cipher_suites, compression_methods and extensions are required")
+		}
+		spec := u.ClientHelloSpec()
+		return &spec, nil
+	}
+	id := defaultID
+	if f != nil {
+		id = f.id
+	}
+	spec, err := tls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, fmt.Errorf("uTlsConn.generateRandomizedSpec error: %+v", err)
+	}
+	shareHybridGroups(&spec)
+	return &spec, nil
+}
+
+// hybridGroups are post-quantum key exchanges uTLS can not generate a key share for in a HelloRetryRequest.
+var hybridGroups = []tls.CurveID{tls.X25519MLKEM768, tls.X25519Kyber768Draft00}
+
+// shareHybridGroups adds key shares for hybrid groups the spec advertises without one. Randomized profiles may
+// advertise X25519MLKEM768 alone, servers preferring it like crypto/tls then ask for it by HelloRetryRequest
+// and the handshake fails. Browsers always send the hybrid key share, so browser profiles are unchanged.
+func shareHybridGroups(spec *tls.ClientHelloSpec) {
+	var curves *tls.SupportedCurvesExtension
+	var keyShares *tls.KeyShareExtension
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *tls.SupportedCurvesExtension:
+			curves = e
+		case *tls.KeyShareExtension:
+			keyShares = e
+		}
+	}
+	if curves == nil || keyShares == nil {
+		return
+	}
+	for _, group := range hybridGroups {
+		if !slices.Contains(curves.Curves, group) || slices.ContainsFunc(keyShares.KeyShares, func(share tls.KeyShare) bool {
+			return share.Group == group
+		}) {
+			continue
+		}
+		keyShares.KeyShares = append([]tls.KeyShare{{Group: group}}, keyShares.KeyShares...)
+	}
+}
+
+// forceHTTP1ALPN restricts advertised ALPN to http/1.1, web socket upgrade fails if server selects h2.
+// This is a deliberate deviation from browser profiles on WStunnel remotes: browsers offer h2 and http/1.1, so the
+// ALPN extension no longer matches the browser. Stunnel remotes send the profile unchanged.
+func forceHTTP1ALPN(spec *tls.ClientHelloSpec) {
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*tls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	tls "github.com/refraction-networking/utls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestShareHybridGroups(t *testing.T) {
+	spec := &tls.ClientHelloSpec{Extensions: []tls.TLSExtension{
+		&tls.SupportedCurvesExtension{Curves: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256}},
+		&tls.KeyShareExtension{KeyShares: []tls.KeyShare{{Group: tls.X25519}}},
+	}}
+	shareHybridGroups(spec)
+	shares := spec.Extensions[1].(*tls.KeyShareExtension).KeyShares
+	if len(shares) != 2 || shares[0].Group != tls.X25519MLKEM768 || shares[1].Group != tls.X25519 {
+		t.Fatalf("advertised hybrid group should get a key share, got %v", shares)
+	}
+	shareHybridGroups(spec)
+	if shares = spec.Extensions[1].(*tls.KeyShareExtension).KeyShares; len(shares) != 2 {
+		t.Errorf("existing key share should not be repeated, got %v", shares)
+	}
+}
+
+// clientHelloSpecJSON is a minimal TLS 1.3 ClientHelloSpec in uTLS JSON format.
+const clientHelloSpecJSON = `{
+	"cipher_suites": ["TLS_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"],
+	"compression_methods": ["NULL"],
+	"extensions": [
+		{"name": "server_name"},
+		{"name": "supported_groups", "named_group_list": ["x25519", "secp256r1"]},
+		{"name": "application_layer_protocol_negotiation", "protocol_name_list": ["h2", "http/1.1"]},
+		{"name": "signature_algorithms", "supported_signature_algorithms": ["ecdsa_secp256r1_sha256", "rsa_pss_rsae_sha256"]},
+		{"name": "key_share", "client_shares": [{"group": "x25519", "key_exchange": []}]},
+		{"name": "supported_versions", "versions": ["TLS 1.3", "TLS 1.2"]}
+	]
+}`
+
+func TestNewFingerprintProfiles(t *testing.T) {
+	for name, id := range fingerprintProfiles {
+		f, err := NewFingerprint(strings.ToUpper(name))
+		if err != nil {
+			t.Fatalf("profile %s: %s", name, err)
+		}
+		if f.id != id {
+			t.Errorf("profile %s should select %v, got %v", name, id, f.id)
+		}
+		spec, err := f.Spec(tls.HelloCustom)
+		if err != nil || len(spec.Extensions) == 0 {
+			t.Errorf("profile %s should generate a spec, got %v", name, err)
+		}
+	}
+	f, err := NewFingerprint("")
+	if f != nil || err != nil {
+		t.Errorf("empty profile should keep tunnel default, got %v %v", f, err)
+	}
+	if spec, err := f.Spec(tls.HelloChrome_Auto); err != nil || len(spec.Extensions) == 0 {
+		t.Errorf("nil fingerprint should use default id, got %v", err)
+	}
+	if _, err = NewFingerprint("netscape"); err == nil {
+		t.Error("unknown profile should be rejected")
+	}
+}
+
+func TestNewFingerprintSpecFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	f, err := NewFingerprint(write("spec.json", clientHelloSpecJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := f.Spec(tls.HelloChrome_Auto)
+	second, err := f.Spec(tls.HelloChrome_Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.CipherSuites) != 2 || len(second.Extensions) != 6 {
+		t.Errorf("spec should come from file, got %d cipher suites and %d extensions", len(second.CipherSuites), len(second.Extensions))
+	}
+	if first.Extensions[0] == second.Extensions[0] {
+		t.Error("every connection should get its own extensions")
+	}
+	alpn := second.Extensions[2].(*tls.ALPNExtension)
+	if !slices.Equal(alpn.AlpnProtocols, []string{"h2", "http/1.1"}) {
+		t.Errorf("spec ALPN should be kept for Stunnel, got %v", alpn.AlpnProtocols)
+	}
+	forceHTTP1ALPN(second)
+	if !slices.Equal(alpn.AlpnProtocols, []string{"http/1.1"}) {
+		t.Errorf("WStunnel ALPN should be http/1.1 only, got %v", alpn.AlpnProtocols)
+	}
+	if _, err = NewFingerprint(write("invalid.json", "{")); err == nil {
+		t.Error("invalid JSON should be rejected")
+	}
+	if _, err = NewFingerprint(write("partial.json", `{"cipher_suites": ["TLS_AES_128_GCM_SHA256"]}`)); err == nil {
+		t.Error("spec without extensions should be rejected")
+	}
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Fronting
+// separates the address dialed, the TLS server name and the http Host of a remote, empty fields keep the url values.
+// ConnectAddress is host:port, SNI overrides tlsServerName and HostHeader replaces Host of web socket upgrade requests.
+// //////////////////////////////////////////////////////////////////////////////
+type Fronting struct {
+	ConnectAddress string
+	SNI            string
+	HostHeader     string
+}
+
+// WithFronting applies fronting settings to all remotes.
+func WithFronting(fronting Fronting) ClientOption {
+	return func(h *httpClient) {
+		h.fronting = fronting
+	}
+}
+
+// WithRemoteFronting applies fronting settings to one remote url, set fields replace the ones from WithFronting.
+func WithRemoteFronting(remote string, fronting Fronting) ClientOption {
+	return func(h *httpClient) {
+		if h.remoteFronting == nil {
+			h.remoteFronting = make(map[string]Fronting)
+		}
+		h.remoteFronting[strings.TrimSpace(remote)] = fronting
+	}
+}
+
+// merge returns f with fields set in other replacing its own.
+func (f Fronting) merge(other Fronting) Fronting {
+	if other.ConnectAddress != "" {
+		f.ConnectAddress = other.ConnectAddress
+	}
+	if other.SNI != "" {
+		f.SNI = other.SNI
+	}
+	if other.HostHeader != "" {
+		f.HostHeader = other.HostHeader
+	}
+	return f
+}
+
+// Validate checks connect address is host:port and names contain no spaces.
+func (f Fronting) Validate() error {
+	if f.ConnectAddress != "" {
+		if host, port, err := net.SplitHostPort(f.ConnectAddress); err != nil || host == "" || port == "" {
+			return fmt.Errorf("connect address %q must be host:port", f.ConnectAddress)
+		}
+	}
+	if strings.ContainsAny(f.SNI, " \t\r\n/:") {
+		return fmt.Errorf("invalid SNI %q", f.SNI)
+	}
+	if strings.ContainsAny(f.HostHeader, " \t\r\n/") {
+		return fmt.Errorf("invalid host header %q", f.HostHeader)
+	}
+	return nil
+}
+
+// applyFronting sets dial address, server name and Host header of endpoint, Host replaces a Host from WithHeaders.
+func (h *httpClient) applyFronting(endpoint *remoteEndpoint) error {
+	fronting := h.fronting.merge(h.remoteFronting[endpoint.url])
+	if err := fronting.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", endpoint.url, err)
+	}
+	endpoint.connectAddress = fronting.ConnectAddress
+	endpoint.sni = fronting.SNI
+	if fronting.HostHeader == "" {
+		return nil
+	}
+	if endpoint.tunnelType == Stunnel {
+		Logger.Warnf("Host header is ignored for Stunnel remote %s, it has no http handshake", endpoint.url)
+		return nil
+	}
+	if endpoint.headers == nil {
+		endpoint.headers = make(http.Header)
+	}
+	endpoint.headers.Set("Host", fronting.HostHeader)
+	return nil
+}
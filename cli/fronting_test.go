@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrontingValidate(t *testing.T) {
+	fronting := Fronting{ConnectAddress: "front.example.com:443", HostHeader: "all.example.com"}.merge(Fronting{SNI: "front.example.com", HostHeader: "hidden.example.com"})
+	if fronting != (Fronting{ConnectAddress: "front.example.com:443", SNI: "front.example.com", HostHeader: "hidden.example.com"}) {
+		t.Errorf("unexpected merged fronting %+v", fronting)
+	}
+	if err := fronting.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, invalid := range []Fronting{{ConnectAddress: "front.example.com"}, {ConnectAddress: ":443"}, {SNI: "front example"}, {HostHeader: "a\r\nX: y"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
+}
+
+func TestFronting(t *testing.T) {
+	serverNames := make(chan string, 1)
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+	}))
+	server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	// The url host does not resolve, dialing it instead of connect address fails.
+	remote := "wss://hidden.invalid:" + port + "/tcp/127.0.0.1/1"
+	controller := NewController()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1212", remote, WSTunnel, 1600, func(fd int) {}, controller, false, "",
+			WithFronting(Fronting{ConnectAddress: server.Listener.Addr().String(), HostHeader: "hidden.example.com"}),
+			WithHeaders(http.Header{"Host": {"ignored.example.com"}}),
+			WithRemoteFronting(remote, Fronting{SNI: "front.example.com"})).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("tcp", "127.0.0.1:1212")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case serverName := <-serverNames:
+		if serverName != "front.example.com" {
+			t.Errorf("expected SNI front.example.com, got %q", serverName)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no TLS handshake")
+	}
+	select {
+	case r := <-requests:
+		if r.Host != "hidden.example.com" || !strings.HasPrefix(r.URL.Path, "/tcp/") {
+			t.Errorf("unexpected upgrade request host %s path %s", r.Host, r.URL.Path)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no upgrade request")
+	}
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// handshakeHeaders are set by the web socket handshake itself and can not be configured.
+var handshakeHeaders = []string{"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"}
+
+// WithHeaders adds headers to web socket upgrade requests of all remotes. Host replaces the request host
+// while SNI keeps following the url, which allows domain fronting. Stunnel remotes have no http handshake.
+func WithHeaders(headers http.Header) ClientOption {
+	return func(h *httpClient) {
+		h.headers = headers
+	}
+}
+
+// WithRemoteHeaders adds headers for one remote url, they replace headers of the same name from WithHeaders.
+func WithRemoteHeaders(remote string, headers http.Header) ClientOption {
+	return func(h *httpClient) {
+		if h.remoteHeaders == nil {
+			h.remoteHeaders = make(map[string]http.Header)
+		}
+		h.remoteHeaders[strings.TrimSpace(remote)] = headers
+	}
+}
+
+// ParseHeaders parses "Name: value" lines like curl -H, empty lines are skipped.
+func ParseHeaders(lines []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("header %q must look like Name: value", line)
+		}
+		if err := addHeader(headers, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
+
+// HeadersFromMap converts configuration map to headers.
+func HeadersFromMap(values map[string]string) (http.Header, error) {
+	headers := make(http.Header)
+	for name, value := range values {
+		if err := addHeader(headers, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return headers, nil
+}
+
+// addHeader validates header and adds it with canonical name.
+func addHeader(headers http.Header, name string, value string) error {
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if name == "" || strings.ContainsAny(name, " \t\r\n\"(),/:;<=>?@[\\]{}") {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %s value must be a single line", name)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	for _, reserved := range handshakeHeaders {
+		if name == reserved {
+			return fmt.Errorf("header %s is set by the web socket handshake", name)
+		}
+	}
+	headers.Add(name, value)
+	return nil
+}
+
+// endpointHeaders merges remote specific headers over headers for all remotes.
+func (h *httpClient) endpointHeaders(remote string) http.Header {
+	headers := h.headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	for name, values := range h.remoteHeaders[remote] {
+		headers[name] = values
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// redirectHeaders drops Host override and credentials when a redirect leaves the host of the original url.
+func redirectHeaders(headers http.Header, from string, to string) http.Header {
+	fromURL, fromErr := url.Parse(from)
+	toURL, toErr := url.Parse(to)
+	if headers == nil || (fromErr == nil && toErr == nil && fromURL.ResolveReference(toURL).Host == fromURL.Host) {
+		return headers
+	}
+	headers = headers.Clone()
+	headers.Del("Host")
+	headers.Del("Authorization")
+	headers.Del("Cookie")
+	return headers
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders([]string{"authorization: Bearer token", "", "Cookie: a=1; b=2", "X-Trace:  1 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("Authorization") != "Bearer token" || headers.Get("Cookie") != "a=1; b=2" || headers.Get("X-Trace") != "1" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+	for _, line := range []string{"NoColon", ": value", "Bad Name: x", "Sec-WebSocket-Key: x", "upgrade: h2c"} {
+		if _, err = ParseHeaders([]string{line}); err == nil {
+			t.Errorf("%q should be rejected", line)
+		}
+	}
+}
+
+func TestRedirectHeaders(t *testing.T) {
+	headers := http.Header{"Host": {"backend"}, "Authorization": {"Bearer token"}, "User-Agent": {"ua"}}
+	if same := redirectHeaders(headers, "wss://a/tcp/1/1", "/tcp/2/2"); same.Get("Authorization") == "" {
+		t.Error("same host redirect should keep credentials")
+	}
+	other := redirectHeaders(headers, "wss://a/tcp/1/1", "wss://b/tcp/1/1")
+	if other.Get("Host") != "" || other.Get("Authorization") != "" || other.Get("User-Agent") != "ua" {
+		t.Errorf("unexpected headers after cross host redirect %v", other)
+	}
+	if headers.Get("Authorization") == "" {
+		t.Error("original headers should not change")
+	}
+}
+
+func TestHandshakeHeaders(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+	}))
+	defer server.Close()
+	remote := "ws" + strings.TrimPrefix(server.URL, "http") + "/tcp/127.0.0.1/1"
+	controller := NewController()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1211", remote, WSTunnel, 1600, func(fd int) {}, controller, false, "",
+			WithHeaders(http.Header{"Host": {"front.example.com"}, "User-Agent": {"Mozilla/5.0"}, "Authorization": {"Bearer all"}}),
+			WithRemoteHeaders(remote, http.Header{"Authorization": {"Bearer remote"}, "Origin": {"https://example.com"}})).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("tcp", "127.0.0.1:1211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case r := <-requests:
+		if r.Host != "front.example.com" || r.UserAgent() != "Mozilla/5.0" || r.Header.Get("Authorization") != "Bearer remote" || r.Header.Get("Origin") != "https://example.com" {
+			t.Errorf("unexpected upgrade request host %s headers %v", r.Host, r.Header)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no upgrade request")
+	}
+}
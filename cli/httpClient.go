@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github.com/gorilla/websocket"
 	tls "github.com/refraction-networking/utls"
+	"io"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,125 +22,454 @@ const WSTunnel = 1
 //export Stunnel wraps OpenVPN tcp traffic in to regular tcp.
 const Stunnel = 2
 
-//export Channel is used by host app to send events to http client.
-var Channel = make(chan string)
+//export UDPTunnel wraps OpenVPN/WireGuard udp datagrams in to Websocket messages.
+const UDPTunnel = 3
+
+// clientRuntime
+// is state shared by all settings generations of a running client, see Controller.Reload.
+type clientRuntime struct {
+	callback   func(fd int)
+	controller *Controller
+	stopped    chan struct{}
+	udpFlows   *udpFlowTable
+	bridges    *bridgeTracker
+	counters   clientCounters
+	genMu      sync.Mutex
+	gen        *httpClient
+	retired    []*httpClient
+	listener   net.Listener
+	// udpListener is the current udp socket of udp tunnels.
+	udpListener *udpListener
+	control     *reverseControl
+}
 
 // httpClient
-// sets up tcp server and remote connections.
+// sets up tcp server and remote connections. Each reload creates a new httpClient with the same runtime,
+// connections keep using the settings of the generation that accepted them.
 // //////////////////////////////////////////////////////////////////////////////
 type httpClient struct {
+	*clientRuntime
 	listenTCP     string
 	remoteServer  string
 	tunnelType    int
 	mtu           int
-	callback      func(fd int)
-	channel       chan string
 	extraPadding  bool
 	tlsServerName string
+	verifier      *PeerVerifier
+	clientCert    *tls.Certificate
+	fingerprint   *Fingerprint
+	reconnect     ReconnectPolicy
+	onReconnect   func(attempt int, err error)
+	failover      string
+	cooldown      time.Duration
+	remotes       *remotePool
+	raceDelay     time.Duration
+	pingInterval  time.Duration
+	pongTimeout   time.Duration
+	multiplex     bool
+	muxMu         sync.Mutex
+	mux           *muxSession
+	// muxDial is closed when the session dial in progress finished, nil when none is.
+	muxDial        chan struct{}
+	udpIdle        time.Duration
+	socks          bool
+	socksUsername  string
+	socksPassword  string
+	httpProxy      bool
+	reverse        bool
+	statsInterval  time.Duration
+	headers        http.Header
+	remoteHeaders  map[string]http.Header
+	fronting       Fronting
+	remoteFronting map[string]Fronting
+	auth           *TokenAuth
+	authTransport  string
+	redirects      RedirectPolicy
+	upstreamProxy  *UpstreamProxy
+}
+
+// ClientOption configures optional httpClient features.
+type ClientOption func(h *httpClient)
+
+// WithPeerVerifier enforces certificate pinning or custom CA verification on both tunnel types.
+func WithPeerVerifier(verifier *PeerVerifier) ClientOption {
+	return func(h *httpClient) {
+		h.verifier = verifier
+	}
+}
+
+// WithClientCertificate presents the certificate to servers requesting client authentication.
+func WithClientCertificate(cert *tls.Certificate) ClientOption {
+	return func(h *httpClient) {
+		h.clientCert = cert
+	}
+}
+
+// WithFingerprint selects the ClientHello profile used by both tunnel types.
+func WithFingerprint(fingerprint *Fingerprint) ClientOption {
+	return func(h *httpClient) {
+		h.fingerprint = fingerprint
+	}
+}
+
+// WithFailover selects order in which comma separated remotes are tried and how long a failing one is skipped.
+func WithFailover(strategy string, cooldown time.Duration) ClientOption {
+	return func(h *httpClient) {
+		h.failover = strategy
+		h.cooldown = cooldown
+	}
+}
+
+// WithRaceDelay races connections to all resolved addresses of all remotes, starting next
+// attempt after stagger delay or as soon as previous one fails. Zero dials one at a time.
+func WithRaceDelay(stagger time.Duration) ClientOption {
+	return func(h *httpClient) {
+		h.raceDelay = stagger
+	}
+}
+
+// WithKeepalive pings web socket tunnels every pingInterval and closes them when the peer stops answering.
+func WithKeepalive(pingInterval time.Duration, pongTimeout time.Duration) ClientOption {
+	return func(h *httpClient) {
+		h.pingInterval = pingInterval
+		h.pongTimeout = pongTimeout
+	}
+}
+
+// NewHTTPClient creates client controlled by controller, nil creates a controller that is never stopped.
+func NewHTTPClient(listenTCP, remoteServer string, tunnelType int, mtu int, callback func(fd int), controller *Controller, extraPadding bool, tlsServerName string, options ...ClientOption) Runner {
+	if controller == nil {
+		controller = NewController()
+	}
+	runtime := &clientRuntime{
+		callback:   callback,
+		controller: controller,
+		stopped:    controller.stopping,
+		udpFlows:   newUDPFlowTable(),
+		bridges:    newBridgeTracker(),
+	}
+	runtime.gen = runtime.newGeneration(listenTCP, remoteServer, tunnelType, mtu, extraPadding, tlsServerName, options)
+	return runtime.gen
 }
 
-func NewHTTPClient(listenTCP, remoteServer string, tunnelType int, mtu int, callback func(fd int), channel chan string, extraPadding bool, tlsServerName string) Runner {
-	return &httpClient{
+// newGeneration creates client settings sharing runtime r.
+func (r *clientRuntime) newGeneration(listenTCP, remoteServer string, tunnelType int, mtu int, extraPadding bool, tlsServerName string, options []ClientOption) *httpClient {
+	h := &httpClient{
+		clientRuntime: r,
 		listenTCP:     listenTCP,
 		remoteServer:  remoteServer,
 		tunnelType:    tunnelType,
 		mtu:           mtu,
-		callback:      callback,
-		channel:       channel,
 		extraPadding:  extraPadding,
 		tlsServerName: tlsServerName,
+		redirects:     DefaultRedirectPolicy(),
+		upstreamProxy: UpstreamProxyFromEnvironment(),
 	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
 }
 
-// Run stars tcp server and connect to remote server.
-func (h *httpClient) Run() error {
-	tcpAdr, err := net.ResolveTCPAddr("tcp", h.listenTCP)
-	if err != nil {
-		Logger.Errorf("Error resolving tcp address: %s", err)
+// Run stars tcp server and connect to remote server, it returns once the controller stops it.
+func (h *httpClient) Run() (err error) {
+	if err = h.controller.start(h.listenTCP, h.Stats, h.reload); err != nil {
 		return err
 	}
-	tcpConnection, err := net.ListenTCP("tcp", tcpAdr)
-	if err != nil {
+	go h.logStats()
+	defer func() {
+		h.controller.finish(err, h.drain(h.controller.drainDeadline()))
+	}()
+	if err = h.prepare(); err != nil {
 		return err
 	}
-	defer tcpConnection.Close()
-	Logger.Infof("Listening on %s", h.listenTCP)
-	doneMutex := sync.Mutex{}
-	done := false
-	isDone := func() bool {
-		doneMutex.Lock()
-		defer doneMutex.Unlock()
-		return done
+	if h.reverse {
+		return h.runReverse()
 	}
-	go func() {
-		select {
-		case msg := <-h.channel:
-			if msg == "done" {
-				doneMutex.Lock()
-				defer doneMutex.Unlock()
-				done = true
-				_ = tcpConnection.Close()
-			}
-		}
+	if h.remotes.udp() {
+		return h.runUDP()
+	}
+	if err = h.listen(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = h.currentListener().Close()
 	}()
+	isDone := h.watchStop(closerFunc(func() error {
+		return h.currentListener().Close()
+	}))
 	for !isDone() {
-		tcpConn, err := tcpConnection.Accept()
+		tcpConn, err := h.currentListener().Accept()
 		if err != nil {
 			continue
 		}
+		if h.controller.paused() {
+			Logger.Infof("Paused, rejecting connection from %s", tcpConn.RemoteAddr().String())
+			_ = tcpConn.Close()
+			continue
+		}
 		Logger.Infof("New connection from %s", tcpConn.RemoteAddr().String())
-		if h.tunnelType == WSTunnel {
-			handleWsTunnelConnection(h, tcpConn)
-		} else if h.tunnelType == Stunnel {
-			handleStunnelConnection(h, tcpConn)
-		} else {
-			Logger.Fatal("Invalid tunnel type specified.")
+		g := h.generation()
+		go func() {
+			remoteAddr := tcpConn.RemoteAddr().String()
+			h.controller.connectionOpened(remoteAddr)
+			defer h.controller.connectionClosed(remoteAddr)
+			handleConnection(g, tcpConn)
+		}()
+	}
+	return nil
+}
+
+// prepare parses remotes and checks they fit the local protocol, it runs for every generation.
+func (h *httpClient) prepare() error {
+	remotes, err := newRemotePool(h.remoteServer, h.tunnelType, h.failover, h.cooldown)
+	if err != nil {
+		Logger.Errorf("Invalid remote address: %s", err)
+		return err
+	}
+	h.remotes = remotes
+	for _, endpoint := range remotes.endpoints {
+		endpoint.headers = h.endpointHeaders(endpoint.url)
+		if err = h.applyFronting(endpoint); err != nil {
+			Logger.Errorf("Invalid fronting settings: %s", err)
+			return err
 		}
 	}
-	return err
+	if err = h.prepareAuth(); err != nil {
+		Logger.Errorf("Invalid auth settings: %s", err)
+		return err
+	}
+	if h.socks && h.httpProxy {
+		err = fmt.Errorf("SOCKS5 and HTTP proxy can not be served on the same listener")
+		Logger.Errorf("Invalid configuration: %s", err)
+		return err
+	}
+	for _, endpoint := range remotes.endpoints {
+		if (h.socks || h.httpProxy) && endpoint.tunnelType != WSTunnel {
+			err = fmt.Errorf("%s can not select proxy destination", endpoint.url)
+			Logger.Errorf("Invalid remote address: %s", err)
+			return err
+		}
+	}
+	if h.reverse {
+		return h.prepareReverse()
+	}
+	if remotes.udp() {
+		h.prepareUDP()
+	}
+	return nil
 }
 
-func handleStunnelConnection(h *httpClient, localConn net.Conn) {
-	remoteConn, err := h.createRemoteConnection()
+// listen binds tcp listener on listen address of the current generation.
+func (r *clientRuntime) listen() error {
+	r.genMu.Lock()
+	defer r.genMu.Unlock()
+	listener, err := bindTCP(r.gen.listenTCP)
 	if err != nil {
-		Logger.Errorf("%s - Remote server connection > Error while dialing %s: %s", localConn.RemoteAddr(), h.remoteServer, err)
-		_ = localConn.Close()
+		return err
+	}
+	r.listener = listener
+	return nil
+}
+
+// bindTCP listens on tcp address.
+func bindTCP(address string) (net.Listener, error) {
+	tcpAdr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		Logger.Errorf("Error resolving tcp address: %s", err)
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp", tcpAdr)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof("Listening on %s", address)
+	return listener, nil
+}
+
+// generation returns settings for newly accepted connections.
+func (r *clientRuntime) generation() *httpClient {
+	r.genMu.Lock()
+	defer r.genMu.Unlock()
+	return r.gen
+}
+
+// currentListener returns tcp listener, it changes when reload moves the listen address.
+func (r *clientRuntime) currentListener() net.Listener {
+	r.genMu.Lock()
+	defer r.genMu.Unlock()
+	return r.listener
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// watchStop closes listener once the controller stops the client and reports whether it did.
+func (h *httpClient) watchStop(listener io.Closer) func() bool {
+	go func() {
+		<-h.stopped
+		_ = listener.Close()
+	}()
+	return func() bool {
+		select {
+		case <-h.stopped:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// handleConnection dials remotes in failover order and bridges the first one that connects.
+func handleConnection(h *httpClient, localConn net.Conn) {
+	if h.socks {
+		h.handleSocksConnection(localConn)
+		return
+	}
+	if h.httpProxy {
+		h.handleHTTPProxyConnection(localConn)
 		return
 	}
-	err = remoteConn.HandshakeContext(context.Background())
+	var leg *tunnelLeg
+	err := h.dialWithRetry(localConn.RemoteAddr().String(), func() error {
+		var err error
+		leg, err = h.openLeg(localConn.RemoteAddr().String(), "")
+		return err
+	})
 	if err != nil {
+		Logger.Errorf("%s - Remote server connection > Error while dialing %s: %s", localConn.RemoteAddr(), h.remoteServer, err)
 		_ = localConn.Close()
-		Logger.Errorf("Error on handshake: %s", err)
 		return
 	}
+	h.runBridge(h.bridge(leg, localConn))
+}
+
+// tunnelLeg is an established connection to a remote endpoint, either web socket, stunnel or a mux stream.
+type tunnelLeg struct {
+	endpoint *remoteEndpoint
+	wsConn   *websocket.Conn
+	tlsConn  *tls.UConn
+	stream   *muxStream
+}
+
+// bridge creates runner copying traffic between local connection and the leg.
+func (h *httpClient) bridge(leg *tunnelLeg, localConn net.Conn) Runner {
+	if leg.wsConn != nil {
+		return NewBidirConnection(localConn, leg.wsConn, time.Second*10, h.mtu, h.pingInterval, h.pongTimeout)
+	}
+	if leg.stream != nil {
+		return NewStunnelBiDirection(localConn, leg.stream, h.mtu)
+	}
 	Logger.Info("Starting stunnel bi-direction connection.")
-	b := NewStunnelBiDirection(localConn, remoteConn, h.mtu)
-	go b.Run()
+	return NewStunnelBiDirection(localConn, leg.tlsConn, h.mtu)
+}
+
+// close closes the remote connection of a leg that is not bridged.
+func (l *tunnelLeg) close() {
+	if l.wsConn != nil {
+		_ = l.wsConn.Close()
+	}
+	if l.tlsConn != nil {
+		_ = l.tlsConn.Close()
+	}
+	if l.stream != nil {
+		_ = l.stream.Close()
+	}
+}
+
+// dialRemotes tries candidate remotes until one connects, failing ones are marked unhealthy.
+// path replaces the web socket url path when set, see targetPath.
+func (h *httpClient) dialRemotes(remoteAddr string, path string) (*tunnelLeg, error) {
+	if h.raceDelay > 0 {
+		return h.raceRemotes(remoteAddr, path)
+	}
+	var lastErr error
+	for _, endpoint := range h.remotes.candidates() {
+		leg, err := h.dialEndpoint(context.Background(), endpoint, "", remoteAddr, path)
+		if err == nil {
+			h.remotes.markHealthy(endpoint)
+			return leg, nil
+		}
+		lastErr = err
+		h.remotes.markUnhealthy(endpoint)
+	}
+	return nil, lastErr
 }
 
-func (h *httpClient) createRemoteConnection() (*tls.UConn, error) {
-	customNetDialer := h.createDialer()
-	remoteUrl, err := url.Parse(h.remoteServer)
+// dialEndpoint opens a leg to single remote endpoint, dialAddress overrides the url host:port
+// and path the url path when set. Stunnel remotes ignore path, their server decides the destination.
+func (h *httpClient) dialEndpoint(ctx context.Context, endpoint *remoteEndpoint, dialAddress string, remoteAddr string, path string) (*tunnelLeg, error) {
+	leg := &tunnelLeg{endpoint: endpoint}
+	start := time.Now()
+	var err error
+	if dialAddress == "" {
+		dialAddress = endpoint.connectAddress
+	}
+	if endpoint.tunnelType == Stunnel {
+		leg.tlsConn, err = h.dialStunnel(ctx, endpoint.url, dialAddress, endpoint.sni)
+	} else {
+		remote := endpoint.url
+		if path != "" {
+			remote, err = withPath(remote, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		leg.wsConn, err = h.createWsConnection(ctx, remoteAddr, remote, dialAddress, endpoint.headers, endpoint.sni)
+		if err == nil && leg.wsConn == nil {
+			err = websocket.ErrBadHandshake
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	serverName := remoteUrl.Hostname()
-	if h.tlsServerName != "" {
-		serverName = h.tlsServerName
+	h.counters.handshakeDone(time.Since(start))
+	return leg, nil
+}
+
+// dialStunnel connects, completes the tls handshake and verifies the server.
+func (h *httpClient) dialStunnel(ctx context.Context, remote string, dialAddress string, sni string) (*tls.UConn, error) {
+	remoteConn, err := h.createRemoteConnection(ctx, remote, dialAddress, sni)
+	if err != nil {
+		return nil, err
 	}
-	cfg := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         serverName,
+	err = remoteConn.HandshakeContext(ctx)
+	if err != nil {
+		_ = remoteConn.Close()
+		Logger.Errorf("Error on handshake: %s", err)
+		return nil, err
 	}
-	netConn, err := customNetDialer.Dial("tcp", remoteUrl.Host)
+	err = h.verifyPeer(remoteConn.ConnectionState(), h.serverNameFor(remote, sni))
+	if err != nil {
+		_ = remoteConn.Close()
+		return nil, err
+	}
+	return remoteConn, nil
+}
+
+func (h *httpClient) createRemoteConnection(ctx context.Context, remote string, dialAddress string, sni string) (*tls.UConn, error) {
+	remoteUrl, err := url.Parse(remote)
+	if err != nil {
+		return nil, err
+	}
+	if dialAddress == "" {
+		dialAddress = hostPort(remoteUrl)
+	}
+	cfg := h.tlsConfig(h.serverNameFor(remote, sni))
+	netConn, err := h.dialTCP(ctx, dialAddress, true)
 	if err != nil {
 		return nil, err
 	}
 
 	remoteConn := tls.UClient(netConn, cfg, tls.HelloCustom)
-	clientHelloSpec, err := tls.UTLSIdToSpec(tls.HelloRandomizedALPN)
+	clientHelloSpec, err := h.fingerprint.Spec(tls.HelloRandomizedALPN)
 	if err != nil {
-		return nil, fmt.Errorf("uTlsConn.generateRandomizedSpec error: %+v", err)
+		_ = netConn.Close()
+		return nil, err
 	}
 
 	if h.extraPadding {
@@ -158,23 +489,24 @@ func (h *httpClient) createRemoteConnection() (*tls.UConn, error) {
 		}
 	}
 
-	err = remoteConn.ApplyPreset(&clientHelloSpec)
+	err = remoteConn.ApplyPreset(clientHelloSpec)
 	if err != nil {
+		_ = netConn.Close()
 		return nil, fmt.Errorf("uTlsConn.ApplyPreset error: %+v", err)
 	}
 
 	return remoteConn, nil
 }
 
-func handleWsTunnelConnection(h *httpClient, tcpConn net.Conn) {
-	wsConn, wsErr := h.createWsConnection(tcpConn.RemoteAddr().String())
-	if wsErr != nil || wsConn == nil {
-		Logger.Errorf("%s - Ws connection > Error while dialing %s: %s", tcpConn.RemoteAddr(), h.remoteServer, wsErr)
-		_ = tcpConn.Close()
-		return
+// hostPort returns url host with default port of the scheme when it has none.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "ws" || u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80")
 	}
-	b := NewBidirConnection(tcpConn, wsConn, time.Second*10, h.mtu)
-	go b.Run()
+	return net.JoinHostPort(u.Hostname(), "443")
 }
 
 func (h *httpClient) toUrl(asString string) (string, error) {
@@ -185,6 +517,45 @@ func (h *httpClient) toUrl(asString string) (string, error) {
 	return asURL.String(), nil
 }
 
+// serverNameFor returns SNI for remote, sni of the remote takes precedence over tlsServerName override and url host.
+func (h *httpClient) serverNameFor(remote string, sni string) string {
+	if sni != "" {
+		return sni
+	}
+	if h.tlsServerName != "" {
+		return h.tlsServerName
+	}
+	if u, err := url.Parse(remote); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// tlsConfig creates client tls config shared by both tunnel types.
+// Server is verified by verifyPeer after the handshake instead of the default verification.
+func (h *httpClient) tlsConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+	}
+	if h.clientCert != nil {
+		cfg.Certificates = []tls.Certificate{*h.clientCert}
+	}
+	return cfg
+}
+
+// verifyPeer checks server certificates if pinning or custom CA is configured.
+func (h *httpClient) verifyPeer(state tls.ConnectionState, serverName string) error {
+	if h.verifier == nil {
+		return nil
+	}
+	err := h.verifier.Verify(state.PeerCertificates, serverName)
+	if err != nil {
+		Logger.Errorf("Server certificate verification failed: %s", err)
+	}
+	return err
+}
+
 // createDialer creates custom dialer which provides access to socket fd
 func (h *httpClient) createDialer() *net.Dialer {
 	customNetDialer := &net.Dialer{}
@@ -199,9 +570,11 @@ func (h *httpClient) createDialer() *net.Dialer {
 	return customNetDialer
 }
 
-// createWsConnection creates a connection to websocket server.
-func (h *httpClient) createWsConnection(remoteAddr string) (wsConn *websocket.Conn, err error) {
-	wsConnectUrl := h.remoteServer
+// createWsConnection creates a connection to websocket server with extra request headers.
+// dialAddress and sni replace the url host:port and server name for the first request only, redirects are dialed as usual.
+func (h *httpClient) createWsConnection(ctx context.Context, remoteAddr string, remote string, dialAddress string, headers http.Header, sni string) (wsConn *websocket.Conn, err error) {
+	wsConnectUrl := remote
+	redirects := newRedirectTracker(h.redirects, remote)
 	for {
 		var wsURL string
 		wsURL, err = h.toUrl(wsConnectUrl)
@@ -211,34 +584,59 @@ func (h *httpClient) createWsConnection(remoteAddr string) (wsConn *websocket.Co
 		Logger.Infof("%s - Connecting to %s", remoteAddr, wsURL)
 		var httpResponse *http.Response
 		dialer := *websocket.DefaultDialer
-		tlsServerName := h.tlsServerName
-		if tlsServerName == "" {
-			if u, e := url.Parse(wsConnectUrl); e == nil {
-				tlsServerName = u.Hostname()
+		tlsServerName := h.serverNameFor(wsConnectUrl, sni)
+		dialer.TLSClientConfig = h.tlsConfig(tlsServerName)
+		if h.multiplex {
+			dialer.Subprotocols = []string{MuxSubprotocol}
+		}
+		dialer.ClientHelloSpec = func() (*tls.ClientHelloSpec, error) {
+			spec, err := h.fingerprint.Spec(tls.HelloRandomizedNoALPN)
+			if err != nil {
+				return nil, err
 			}
+			forceHTTP1ALPN(spec)
+			return spec, nil
 		}
-		tlsCfg := &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         tlsServerName,
+		dialer.VerifyConnection = func(state tls.ConnectionState) error {
+			return h.verifyPeer(state, tlsServerName)
 		}
-		dialer.TLSClientConfig = tlsCfg
-		customNetDialer := h.createDialer()
-		dialer.NetDial = func(network, addr string) (net.Conn, error) {
-			return customNetDialer.Dial(network, addr)
+		// Upstream proxy is dialed by dialTCP so both tunnel types use the same proxy settings.
+		dialer.Proxy = nil
+		connectAddress := dialAddress
+		secure := strings.HasPrefix(wsURL, "wss:")
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if connectAddress != "" {
+				addr = connectAddress
+			}
+			return h.dialTCP(ctx, addr, secure)
 		}
-		wsConn, httpResponse, err = dialer.Dial(wsURL, nil)
+		var requestHeaders http.Header
+		requestHeaders, err = h.signRequest(&dialer, headers, wsURL)
+		if err != nil {
+			return
+		}
+		wsConn, httpResponse, err = dialer.DialContext(ctx, wsURL, requestHeaders)
 		if wsConn != nil {
 			Logger.Info("Successfully connected to remote server.")
 		} else if err != nil {
 			Logger.Errorf("Failed to connect to remote server.. %s", err)
 		}
-		if httpResponse != nil {
-			switch httpResponse.StatusCode {
-			case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
-				wsConnectUrl = httpResponse.Header.Get("Location")
-				Logger.Infof("%s - Redirect to %s", remoteAddr, wsConnectUrl)
-				continue
+		if httpResponse != nil && httpResponse.StatusCode == http.StatusGatewayTimeout {
+			err = ErrTargetTimeout
+		}
+		if httpResponse != nil && isRedirect(httpResponse.StatusCode) {
+			var location string
+			location, err = redirects.next(wsURL, httpResponse.Header.Get("Location"))
+			if err != nil {
+				Logger.Errorf("%s - Not following redirect: %s", remoteAddr, err)
+				return
 			}
+			headers = redirectHeaders(headers, wsURL, location)
+			wsConnectUrl = location
+			dialAddress = ""
+			sni = ""
+			Logger.Infof("%s - Redirect to %s", remoteAddr, wsConnectUrl)
+			continue
 		}
 		return
 	}
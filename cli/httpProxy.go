@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithHTTPProxy turns the local listener into an HTTP proxy serving CONNECT and absolute-URI requests.
+// Requests reach their destination through web socket remotes, Stunnel remotes can not select it and are rejected.
+func WithHTTPProxy(enabled bool) ClientOption {
+	return func(h *httpClient) {
+		h.httpProxy = enabled
+	}
+}
+
+// handleHTTPProxyConnection reads proxy request, opens a leg to its destination and bridges the connection.
+func (h *httpClient) handleHTTPProxyConnection(localConn net.Conn) {
+	remoteAddr := localConn.RemoteAddr().String()
+	_ = localConn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	reader := bufio.NewReader(localConn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		Logger.Errorf("%s - Invalid proxy request: %s", remoteAddr, err)
+		_ = localConn.Close()
+		return
+	}
+	_ = localConn.SetReadDeadline(time.Time{})
+	target, err := proxyTarget(request)
+	if err != nil {
+		Logger.Errorf("%s - Invalid proxy request: %s", remoteAddr, err)
+		writeProxyError(localConn, http.StatusBadRequest, err)
+		return
+	}
+	Logger.Infof("%s - HTTP proxy %s %s", remoteAddr, request.Method, target)
+	var leg *tunnelLeg
+	err = h.dialWithRetry(remoteAddr, func() error {
+		var err error
+		leg, err = h.openLeg(remoteAddr, target)
+		return err
+	})
+	if err != nil {
+		Logger.Errorf("%s - Remote server connection > Error while dialing %s for %s: %s", remoteAddr, h.remoteServer, target, err)
+		writeProxyError(localConn, dialErrorStatus(err), err)
+		return
+	}
+	var forward bytes.Buffer
+	switch {
+	case request.Method == http.MethodConnect:
+		_, err = io.WriteString(localConn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	default:
+		removeProxyHeaders(request.Header)
+		writeRequestHead(&forward, request)
+	}
+	if err == nil && forward.Len() > 0 {
+		err = leg.write(forward.Bytes())
+	}
+	if err != nil {
+		Logger.Errorf("%s - Error forwarding proxy request: %s", remoteAddr, err)
+		leg.close()
+		_ = localConn.Close()
+		return
+	}
+	h.runBridge(h.bridge(leg, &bufferedConn{Conn: localConn, reader: reader}))
+}
+
+// writeRequestHead writes request line and headers in origin form, the body is left in the reader and streamed
+// by the bridge so large uploads are not buffered and Expect: 100-continue reaches the destination.
+func writeRequestHead(w io.Writer, request *http.Request) {
+	_, _ = fmt.Fprintf(w, "%s %s HTTP/1.1\r\nHost: %s\r\n", request.Method, request.URL.RequestURI(), request.Host)
+	if len(request.TransferEncoding) > 0 {
+		_, _ = fmt.Fprintf(w, "Transfer-Encoding: %s\r\n", strings.Join(request.TransferEncoding, ", "))
+	} else if request.ContentLength > 0 {
+		request.Header.Set("Content-Length", strconv.FormatInt(request.ContentLength, 10))
+	}
+	_ = request.Header.Write(w)
+	// Clients reuse proxy connections for any host, so each one carries a single request.
+	_, _ = io.WriteString(w, "Connection: close\r\n\r\n")
+}
+
+// proxyTarget returns host:port requested by CONNECT or absolute-URI request.
+func proxyTarget(request *http.Request) (string, error) {
+	if request.Method == http.MethodConnect {
+		if _, _, err := net.SplitHostPort(request.URL.Host); err != nil {
+			return "", fmt.Errorf("CONNECT target must be host:port, got %q", request.URL.Host)
+		}
+		return request.URL.Host, nil
+	}
+	if !request.URL.IsAbs() || request.URL.Host == "" {
+		return "", fmt.Errorf("request URI must be absolute, got %q", request.RequestURI)
+	}
+	if request.URL.Port() != "" {
+		return request.URL.Host, nil
+	}
+	if request.URL.Scheme == "https" {
+		return net.JoinHostPort(request.URL.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(request.URL.Hostname(), "80"), nil
+}
+
+// removeProxyHeaders drops headers meant for the proxy before request is sent to the destination.
+func removeProxyHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range []string{"Connection", "Proxy-Connection", "Proxy-Authorization", "Keep-Alive", "Te", "Trailer", "Upgrade"} {
+		header.Del(name)
+	}
+}
+
+// writeProxyError answers the request with status and closes the connection.
+func writeProxyError(conn net.Conn, status int, err error) {
+	body := err.Error() + "\n"
+	response := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	_ = response.Write(conn)
+	_ = conn.Close()
+}
+
+// dialErrorStatus is 504 when remote or destination did not answer in time and 502 for other dial errors.
+func dialErrorStatus(err error) int {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTargetTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// write sends bytes to the remote side of a leg that is not bridged yet.
+func (l *tunnelLeg) write(p []byte) error {
+	if l.wsConn != nil {
+		return l.wsConn.WriteMessage(websocket.BinaryMessage, p)
+	}
+	_, err := l.stream.Write(p)
+	return err
+}
+
+// bufferedConn reads through reader first so bytes buffered while parsing the request are not lost.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHTTPProxy(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Connection") != "" {
+			t.Error("proxy headers should not reach destination")
+		}
+		_, _ = io.WriteString(w, "plain "+r.URL.Path)
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure "+r.URL.Path)
+	}))
+	defer secure.Close()
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8087", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1200", "ws://127.0.0.1:8087/tcp/127.0.0.1/1", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithHTTPProxy(true)).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	proxyURL, _ := url.Parse("http://127.0.0.1:1200")
+	client := &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	for _, test := range []struct{ url, body string }{
+		{plain.URL + "/a", "plain /a"},
+		{plain.URL + "/b", "plain /b"},
+		{secure.URL + "/c", "secure /c"},
+	} {
+		response, err := client.Get(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if string(body) != test.body {
+			t.Errorf("expected %q, got %q", test.body, body)
+		}
+	}
+	upload := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		_, _ = fmt.Fprintf(w, "%s %d", r.Header.Get("Expect"), n)
+	}))
+	defer upload.Close()
+	uploader := &http.Client{
+		Timeout: time.Second * 5,
+		// Waiting longer than the client timeout fails the upload unless the destination answers 100 Continue.
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), ExpectContinueTimeout: time.Minute},
+	}
+	size := 8 << 20
+	for _, chunked := range []bool{false, true} {
+		var body io.Reader = bytes.NewReader(make([]byte, size))
+		if chunked {
+			body = io.LimitReader(zeroReader{}, int64(size))
+		}
+		request, _ := http.NewRequest(http.MethodPost, upload.URL+"/upload", body)
+		request.Header.Set("Expect", "100-continue")
+		response, err := uploader.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if expected := fmt.Sprintf("100-continue %d", size); string(received) != expected {
+			t.Errorf("expected %q, got %q", expected, received)
+		}
+	}
+	// Nothing listens on port 1, the server fails to reach it.
+	if response, err := client.Get("https://127.0.0.1:1/"); err == nil || response != nil {
+		t.Error("expected CONNECT to unreachable destination to fail")
+	}
+	response, err := client.Get("http://127.0.0.1:1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected bad gateway, got %d", response.StatusCode)
+	}
+	_, _ = controller.Stop(time.Second)
+}
+
+func TestHTTPProxyDialTimeout(t *testing.T) {
+	_, timeout := (&net.Dialer{Timeout: time.Nanosecond}).Dial("tcp", "127.0.0.1:1")
+	if timeout == nil {
+		t.Fatal("dial should time out")
+	}
+	for _, err := range []error{timeout, &ProxyError{Proxy: "socks5://127.0.0.1:1080", Err: timeout}, fmt.Errorf("race: %w", context.DeadlineExceeded)} {
+		if status := dialErrorStatus(err); status != http.StatusGatewayTimeout {
+			t.Errorf("%s should answer gateway timeout, got %d", err, status)
+		}
+	}
+	if status := dialErrorStatus(websocket.ErrBadHandshake); status != http.StatusBadGateway {
+		t.Errorf("failed handshake should answer bad gateway, got %d", status)
+	}
+	local, remote := net.Pipe()
+	go writeProxyError(remote, dialErrorStatus(timeout), timeout)
+	response, err := http.ReadResponse(bufio.NewReader(local), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusGatewayTimeout || !response.Close {
+		t.Errorf("expected gateway timeout closing the connection, got %d", response.StatusCode)
+	}
+}
+
+func TestHTTPProxyTargetTimeout(t *testing.T) {
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8100", "", "", 1600, WithTargetDialTimeout(time.Nanosecond)).Run()
+	}()
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1235", "ws://127.0.0.1:8100/tcp/127.0.0.1/1", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithHTTPProxy(true)).Run()
+	}()
+	defer func() {
+		_, _ = controller.Stop(time.Second)
+	}()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("tcp", "127.0.0.1:1235")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, "CONNECT 127.0.0.1:1 HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("target timeout at the server should answer gateway timeout, got %d", response.StatusCode)
+	}
+}
+
+// zeroReader reads zeros without length, so requests with it are sent chunked.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestHTTPProxyRejectsStunnelRemotes(t *testing.T) {
+	for _, remotes := range []string{"https://127.0.0.1:8443", "ws://127.0.0.1:8087/tcp/127.0.0.1/1,https://127.0.0.1:8443"} {
+		h := NewHTTPClient("127.0.0.1:0", remotes, WSTunnel, 1600, func(fd int) {}, NewController(), false, "", WithHTTPProxy(true)).(*httpClient)
+		if err := h.prepare(); err == nil {
+			t.Errorf("HTTP proxy with Stunnel remote %s should be rejected", remotes)
+		}
+	}
+}
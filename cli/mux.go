@@ -0,0 +1,547 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// MuxSubprotocol is negotiated by clients that carry many streams over one web socket.
+const MuxSubprotocol = "wstunnel-mux"
+
+// Mux frame types. Every binary web socket message is one frame:
+// 1 byte type, 4 bytes big endian stream id and the payload.
+const (
+	// muxOpen opens a stream, payload is optional host:port target.
+	muxOpen byte = iota + 1
+	// muxData carries stream bytes.
+	muxData
+	// muxClose closes a stream in both directions.
+	muxClose
+	// muxWindowUpdate grants peer 4 byte big endian number of bytes more to send.
+	muxWindowUpdate
+	// muxOpenOK acknowledges muxOpen once the stream target is connected.
+	muxOpenOK
+	// muxOpenFail rejects muxOpen, payload is 1 byte failure reason.
+	muxOpenFail
+)
+
+// Reasons carried by muxOpenFail.
+const (
+	muxFailUnreachable byte = iota + 1
+	muxFailTimeout
+	muxFailRejected
+)
+
+const (
+	muxHeaderSize    = 5
+	muxMaxPayload    = 32 * 1024
+	muxInitialWindow = 256 * 1024
+	muxAcceptBacklog = 64
+	// muxOpenTimeout exceeds the default server target dial timeout, so the server reports timeouts first.
+	muxOpenTimeout = time.Second * 15
+)
+
+var errMuxClosed = errors.New("mux session closed")
+
+// errMuxOpenFailed is returned by Open when the server could not reach the stream target.
+var errMuxOpenFailed = errors.New("server could not open stream")
+
+// muxSession
+// carries many logical streams over a single web socket connection.
+// Client opens streams with odd ids, server with even ids.
+type muxSession struct {
+	conn         *websocket.Conn
+	client       bool
+	writeMu      sync.Mutex
+	mu           sync.Mutex
+	streams      map[uint32]*muxStream
+	nextID       uint32
+	accepted     chan *muxStream
+	done         chan struct{}
+	closeOnce    sync.Once
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+}
+
+// newMuxSession starts reading frames from conn, pingInterval of 0 disables keepalive pings.
+func newMuxSession(conn *websocket.Conn, client bool, pingInterval time.Duration, pongTimeout time.Duration) *muxSession {
+	s := &muxSession{
+		conn:         conn,
+		client:       client,
+		streams:      map[uint32]*muxStream{},
+		nextID:       2,
+		accepted:     make(chan *muxStream, muxAcceptBacklog),
+		done:         make(chan struct{}),
+		pingInterval: pingInterval,
+		pongTimeout:  pongTimeout,
+	}
+	if client {
+		s.nextID = 1
+	}
+	if pingInterval > 0 {
+		conn.SetPongHandler(func(string) error {
+			s.extendReadDeadline()
+			return nil
+		})
+		go s.keepalive()
+	}
+	go s.readLoop()
+	return s
+}
+
+// Open starts a new stream to target, empty target lets server use the target from the request path.
+// It returns once the peer connected the target, so callers may report success to their clients.
+func (s *muxSession) Open(target string) (*muxStream, error) {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return nil, errMuxClosed
+	default:
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newMuxStream(s, id, target)
+	stream.opened = make(chan struct{})
+	s.streams[id] = stream
+	s.mu.Unlock()
+	if err := s.writeFrame(muxOpen, id, []byte(target)); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	timer := time.NewTimer(muxOpenTimeout)
+	defer timer.Stop()
+	select {
+	case <-stream.opened:
+	case <-s.done:
+		return nil, errMuxClosed
+	case <-timer.C:
+		_ = stream.Close()
+		return nil, fmt.Errorf("stream to %q not acknowledged: %w", target, os.ErrDeadlineExceeded)
+	}
+	if stream.openErr != nil {
+		return nil, stream.openErr
+	}
+	return stream, nil
+}
+
+// Accept waits for a stream opened by the peer.
+func (s *muxSession) Accept() (*muxStream, error) {
+	select {
+	case stream := <-s.accepted:
+		return stream, nil
+	case <-s.done:
+		return nil, errMuxClosed
+	}
+}
+
+// Done is closed when the session is closed.
+func (s *muxSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close closes web socket connection and every stream.
+func (s *muxSession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		_ = s.conn.Close()
+		s.mu.Lock()
+		streams := make([]*muxStream, 0, len(s.streams))
+		for _, stream := range s.streams {
+			streams = append(streams, stream)
+		}
+		s.mu.Unlock()
+		for _, stream := range streams {
+			stream.remoteClose()
+		}
+	})
+}
+
+// readLoop dispatches frames to streams until the connection fails.
+func (s *muxSession) readLoop() {
+	defer s.Close()
+	for {
+		s.extendReadDeadline()
+		messageType, message, err := s.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if s.pingInterval > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				Logger.Warnf("Mux - Peer %s stopped answering pings for %s, closing session.", s.conn.RemoteAddr(), s.pingInterval+s.pongTimeout)
+			}
+			return
+		}
+		if messageType != websocket.BinaryMessage || len(message) < muxHeaderSize {
+			Logger.Infof("Mux - Got invalid frame from %s", s.conn.RemoteAddr())
+			return
+		}
+		id := binary.BigEndian.Uint32(message[1:muxHeaderSize])
+		payload := message[muxHeaderSize:]
+		switch message[0] {
+		case muxOpen:
+			s.accept(id, string(payload))
+		case muxOpenOK, muxOpenFail:
+			if stream := s.get(id); stream != nil {
+				stream.openDone(message[0], payload)
+			}
+		case muxData:
+			if stream := s.get(id); stream != nil {
+				stream.receive(payload)
+			}
+		case muxClose:
+			if stream := s.get(id); stream != nil {
+				stream.openDone(muxOpenFail, nil)
+				stream.remoteClose()
+			}
+		case muxWindowUpdate:
+			if stream := s.get(id); stream != nil && len(payload) == 4 {
+				stream.addSendWindow(int(binary.BigEndian.Uint32(payload)))
+			}
+		default:
+			Logger.Infof("Mux - Got unknown frame type %d from %s", message[0], s.conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// accept registers stream opened by peer and queues it for Accept.
+// Ids of the wrong parity or already in use are rejected, they would collide with streams opened locally.
+func (s *muxSession) accept(id uint32, target string) {
+	if id == 0 || (id%2 == 1) == s.client {
+		Logger.Warnf("Mux - Rejecting stream %d with wrong id parity from %s", id, s.conn.RemoteAddr())
+		_ = s.writeFrame(muxOpenFail, id, []byte{muxFailRejected})
+		return
+	}
+	s.mu.Lock()
+	if _, exists := s.streams[id]; exists {
+		s.mu.Unlock()
+		return
+	}
+	stream := newMuxStream(s, id, target)
+	s.streams[id] = stream
+	s.mu.Unlock()
+	select {
+	case s.accepted <- stream:
+	default:
+		Logger.Warnf("Mux - Accept backlog full, rejecting stream %d", id)
+		stream.Reject(muxFailRejected)
+	}
+}
+
+func (s *muxSession) get(id uint32) *muxStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *muxSession) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// writeFrame sends one frame, web socket allows a single concurrent writer.
+func (s *muxSession) writeFrame(frameType byte, id uint32, payload []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.done:
+		return errMuxClosed
+	default:
+	}
+	var header [muxHeaderSize]byte
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], id)
+	w, err := s.conn.NextWriter(websocket.BinaryMessage)
+	if err == nil {
+		if _, err = w.Write(header[:]); err == nil {
+			if _, err = w.Write(payload); err == nil {
+				err = w.Close()
+			}
+		}
+	}
+	if err != nil {
+		go s.Close()
+	}
+	return err
+}
+
+// keepalive sends pings until the session is closed.
+func (s *muxSession) keepalive() {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.pongTimeout)); err != nil {
+				Logger.Warnf("Mux - Failed to send ping to %s: %s", s.conn.RemoteAddr(), err)
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *muxSession) extendReadDeadline() {
+	if s.pingInterval > 0 {
+		_ = s.conn.SetReadDeadline(time.Now().Add(s.pingInterval + s.pongTimeout))
+	}
+}
+
+// muxStream
+// is one logical connection inside a mux session, it implements net.Conn.
+type muxStream struct {
+	id            uint32
+	session       *muxSession
+	target        string
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	readBuf       bytes.Buffer
+	recvWindow    int
+	consumed      int
+	sendWindow    int
+	remoteClosed  bool
+	localClosed   bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan struct{}
+	writable      chan struct{}
+	// opened is closed when the peer acknowledged a locally opened stream, openErr is set when it failed.
+	opened  chan struct{}
+	openErr error
+}
+
+func newMuxStream(session *muxSession, id uint32, target string) *muxStream {
+	return &muxStream{
+		id:         id,
+		session:    session,
+		target:     target,
+		recvWindow: muxInitialWindow,
+		sendWindow: muxInitialWindow,
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+	}
+}
+
+// Read reads stream data and grants the peer more window once half of it is consumed.
+func (st *muxStream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.readBuf.Len() > 0 {
+			n, _ := st.readBuf.Read(p)
+			st.consumed += n
+			update := 0
+			if st.consumed >= muxInitialWindow/2 && !st.remoteClosed {
+				update = st.consumed
+				st.recvWindow += update
+				st.consumed = 0
+			}
+			st.mu.Unlock()
+			if update > 0 {
+				var increment [4]byte
+				binary.BigEndian.PutUint32(increment[:], uint32(update))
+				_ = st.session.writeFrame(muxWindowUpdate, st.id, increment[:])
+			}
+			return n, nil
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if err := waitSignal(st.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends p in frames limited by the peer window.
+func (st *muxStream) Write(p []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		if st.localClosed || st.remoteClosed {
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := waitSignal(st.writable, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(len(p)-written, st.sendWindow, muxMaxPayload)
+		st.sendWindow -= n
+		st.mu.Unlock()
+		if err := st.session.writeFrame(muxData, st.id, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close closes stream in both directions and tells the peer.
+func (st *muxStream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	remoteClosed := st.remoteClosed
+	st.mu.Unlock()
+	st.signal()
+	st.session.remove(st.id)
+	if !remoteClosed {
+		_ = st.session.writeFrame(muxClose, st.id, nil)
+	}
+	return nil
+}
+
+// Acknowledge tells the peer the stream target is connected.
+func (st *muxStream) Acknowledge() error {
+	return st.session.writeFrame(muxOpenOK, st.id, nil)
+}
+
+// Reject tells the peer the stream could not be opened for reason and forgets the stream.
+func (st *muxStream) Reject(reason byte) {
+	st.mu.Lock()
+	st.localClosed = true
+	st.remoteClosed = true
+	st.mu.Unlock()
+	st.signal()
+	st.session.remove(st.id)
+	_ = st.session.writeFrame(muxOpenFail, st.id, []byte{reason})
+}
+
+// openDone completes Open with the acknowledgement of the peer, repeated or unexpected ones are ignored.
+func (st *muxStream) openDone(frameType byte, payload []byte) {
+	if st.opened == nil {
+		return
+	}
+	select {
+	case <-st.opened:
+		return
+	default:
+	}
+	if frameType == muxOpenFail {
+		st.openErr = errMuxOpenFailed
+		if len(payload) == 1 && payload[0] == muxFailTimeout {
+			st.openErr = ErrTargetTimeout
+		}
+		st.session.remove(st.id)
+		st.remoteClose()
+	}
+	close(st.opened)
+}
+
+// receive buffers data from peer, peer exceeding its window resets the stream.
+func (st *muxStream) receive(payload []byte) {
+	st.mu.Lock()
+	if len(payload) > st.recvWindow {
+		st.mu.Unlock()
+		Logger.Warnf("Mux - Stream %d exceeded flow control window, closing.", st.id)
+		_ = st.Close()
+		return
+	}
+	st.recvWindow -= len(payload)
+	st.readBuf.Write(payload)
+	st.mu.Unlock()
+	notify(st.readable)
+}
+
+func (st *muxStream) addSendWindow(increment int) {
+	st.mu.Lock()
+	st.sendWindow += increment
+	st.mu.Unlock()
+	notify(st.writable)
+}
+
+func (st *muxStream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	st.mu.Unlock()
+	st.signal()
+}
+
+func (st *muxStream) signal() {
+	notify(st.readable)
+	notify(st.writable)
+}
+
+func (st *muxStream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+func (st *muxStream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+func (st *muxStream) SetDeadline(t time.Time) error {
+	_ = st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *muxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.readable)
+	return nil
+}
+
+func (st *muxStream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.writable)
+	return nil
+}
+
+// notify wakes a waiter without blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// waitSignal waits for ch or deadline, zero deadline waits forever.
+func waitSignal(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
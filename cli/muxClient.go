@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithMultiplexing carries all accepted connections as streams over one long-lived web socket.
+// Stunnel remotes keep using one connection per accepted connection.
+func WithMultiplexing(enabled bool) ClientOption {
+	return func(h *httpClient) {
+		h.multiplex = enabled
+	}
+}
+
+// openLeg dials a new leg, or opens a stream on the shared mux session when multiplexing.
+// target is tcp host:port to reach through the server, empty uses the target from the remote url.
+func (h *httpClient) openLeg(remoteAddr string, target string) (*tunnelLeg, error) {
+	if !h.multiplex {
+		path := ""
+		if target != "" {
+			path = targetPath(TcpPathPrefix, target)
+		}
+		return h.dialRemotes(remoteAddr, path)
+	}
+	session, leg, err := h.muxSession(remoteAddr)
+	if err != nil || leg != nil {
+		return leg, err
+	}
+	stream, err := session.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	return &tunnelLeg{stream: stream}, nil
+}
+
+// muxSession returns the shared session, dialing a new one when there is none or it is closed.
+// When failover picks a Stunnel remote its leg is returned instead. The session is dialed without holding muxMu,
+// connections accepted meanwhile wait for that dial instead of dialing sessions of their own.
+func (h *httpClient) muxSession(remoteAddr string) (*muxSession, *tunnelLeg, error) {
+	h.muxMu.Lock()
+	for {
+		if h.mux != nil {
+			select {
+			case <-h.mux.Done():
+				h.mux = nil
+			default:
+				session := h.mux
+				h.muxMu.Unlock()
+				return session, nil, nil
+			}
+		}
+		if h.muxDial == nil {
+			break
+		}
+		dial := h.muxDial
+		h.muxMu.Unlock()
+		<-dial
+		h.muxMu.Lock()
+	}
+	dial := make(chan struct{})
+	h.muxDial = dial
+	h.muxMu.Unlock()
+	session, leg, err := h.dialMuxSession(remoteAddr)
+	h.muxMu.Lock()
+	defer h.muxMu.Unlock()
+	defer close(dial)
+	if h.muxDial != dial {
+		// closeMux ran while dialing.
+		if session != nil {
+			session.Close()
+			return nil, nil, errors.New("mux session closed while dialing")
+		}
+		return nil, leg, err
+	}
+	h.muxDial = nil
+	if session != nil {
+		h.mux = session
+	}
+	return session, leg, err
+}
+
+// dialMuxSession dials remotes and starts a session on the web socket, Stunnel legs are returned as they are.
+func (h *httpClient) dialMuxSession(remoteAddr string) (*muxSession, *tunnelLeg, error) {
+	leg, err := h.dialRemotes(remoteAddr, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if leg.wsConn == nil {
+		return nil, leg, nil
+	}
+	if leg.wsConn.Subprotocol() != MuxSubprotocol {
+		leg.close()
+		return nil, nil, fmt.Errorf("server %s does not support multiplexing", leg.endpoint.url)
+	}
+	Logger.Infof("Mux session established with %s", leg.endpoint.url)
+	return newMuxSession(leg.wsConn, true, h.pingInterval, h.pongTimeout), nil, nil
+}
+
+// closeMux closes the shared session and all its streams.
+func (h *httpClient) closeMux() {
+	h.muxMu.Lock()
+	defer h.muxMu.Unlock()
+	if h.mux != nil {
+		h.mux.Close()
+		h.mux = nil
+	}
+	// Session dialed meanwhile is closed when its dial returns.
+	h.muxDial = nil
+}
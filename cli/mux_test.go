@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultiplexedConnections(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7004")
+	tunnelServer := NewWsTunnelServer("", "", "", 1600).(*wsTunnelServer)
+	var upgrades int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upgrades, 1)
+		tunnelServer.handleTcpTunnel(w, r)
+	}))
+	defer server.Close()
+	controller := NewController()
+	client := NewHTTPClient("127.0.0.1:1197", "ws"+strings.TrimPrefix(server.URL, "http")+"/tcp/127.0.0.1/7004", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithMultiplexing(true))
+	go func() {
+		_ = client.Run()
+	}()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	time.Sleep(time.Millisecond * 200)
+	// More than the flow control window, so window updates are exercised.
+	payload := make([]byte, muxInitialWindow*3)
+	_, _ = rand.Read(payload)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", "127.0.0.1:1197")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(time.Second * 10))
+			go func() {
+				_, _ = conn.Write(payload)
+			}()
+			received := make([]byte, len(payload))
+			if _, err = io.ReadFull(conn, received); err != nil || !bytes.Equal(received, payload) {
+				t.Errorf("echo mismatch: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&upgrades); n != 1 {
+		t.Errorf("connections should share a single web socket, server got %d upgrade requests", n)
+	}
+	if stats := controller.Stats(); stats.TotalConnections != 4 {
+		t.Errorf("every connection should be tunneled, got %d", stats.TotalConnections)
+	}
+}
+
+func TestMultiplexedOpenWaitsForTarget(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7019")
+	servers := map[string]*wsTunnelServer{
+		"reachable": NewWsTunnelServer("", "", "", 1600).(*wsTunnelServer),
+		"timeout":   NewWsTunnelServer("", "", "", 1600, WithTargetDialTimeout(time.Nanosecond)).(*wsTunnelServer),
+	}
+	for _, test := range []struct {
+		server string
+		target string
+		status int
+	}{
+		{"reachable", "127.0.0.1:7019", http.StatusOK},
+		// Nothing listens on port 1, the server fails to reach it.
+		{"reachable", "127.0.0.1:1", http.StatusBadGateway},
+		{"timeout", "127.0.0.1:7019", http.StatusGatewayTimeout},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(servers[test.server].handleTcpTunnel))
+		controller := NewController()
+		go func() {
+			_ = NewHTTPClient("127.0.0.1:1236", "ws"+strings.TrimPrefix(server.URL, "http")+"/tcp/127.0.0.1/1", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithMultiplexing(true), WithHTTPProxy(true)).Run()
+		}()
+		time.Sleep(time.Millisecond * 200)
+		conn, err := net.Dial("tcp", "127.0.0.1:1236")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+		_, _ = io.WriteString(conn, "CONNECT "+test.target+" HTTP/1.1\r\nHost: "+test.target+"\r\n\r\n")
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("CONNECT %s through %s server should answer %d, got %d", test.target, test.server, test.status, response.StatusCode)
+		}
+		_ = conn.Close()
+		_, _ = controller.Stop(time.Second)
+		server.Close()
+	}
+}
+
+func TestMultiplexedServerRejectsWrongParity(t *testing.T) {
+	sessions := make(chan *muxSession, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		sessions <- newMuxSession(conn, false, 0, 0)
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	session := <-sessions
+	defer session.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_ = conn.WriteMessage(websocket.BinaryMessage, []byte{muxOpen, 0, 0, 0, 2})
+	_, reply, err := conn.ReadMessage()
+	if err != nil || !bytes.Equal(reply, []byte{muxOpenFail, 0, 0, 0, 2, muxFailRejected}) {
+		t.Errorf("even stream id from client should be rejected, got %v %v", reply, err)
+	}
+	_ = conn.WriteMessage(websocket.BinaryMessage, []byte{muxOpen, 0, 0, 0, 1})
+	stream, err := session.Accept()
+	if err != nil || stream.id != 1 {
+		t.Errorf("odd stream id from client should be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrCertificatePinMismatch is returned when none of the server certificates match a pinned key.
+var ErrCertificatePinMismatch = errors.New("certificate pin mismatch")
+
+// PeerVerifier
+// checks the server certificate chain against pinned SPKI sha256 hashes and/or a custom CA bundle.
+// Both checks must pass when both are configured.
+type PeerVerifier struct {
+	pins  [][]byte
+	roots *x509.CertPool
+}
+
+// NewPeerVerifier creates verifier from pins (base64, sha256/base64 or hex) and CA bundle PEM file path.
+// Returns nil verifier if nothing is configured.
+func NewPeerVerifier(pins []string, caBundlePath string) (*PeerVerifier, error) {
+	v := &PeerVerifier{}
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if pin == "" {
+			continue
+		}
+		hash, err := decodePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		v.pins = append(v.pins, hash)
+	}
+	if caBundlePath != "" {
+		pem, err := os.ReadFile(caBundlePath)
+		if err != nil {
+			return nil, err
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundlePath)
+		}
+	}
+	if len(v.pins) == 0 && v.roots == nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+func decodePin(pin string) ([]byte, error) {
+	if hash, err := base64.StdEncoding.DecodeString(pin); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	if hash, err := hex.DecodeString(strings.ReplaceAll(pin, ":", "")); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	return nil, fmt.Errorf("invalid SPKI sha256 pin %q", pin)
+}
+
+// Verify checks certificates presented by the server after the handshake.
+// Pins are matched against the verified chain only, so certificates appended to a chain that does not lead to them are ignored.
+// Without CA bundle a pinned leaf is trusted as is, a pinned issuer only for a leaf valid for serverName.
+func (v *PeerVerifier) Verify(certs []*x509.Certificate, serverName string) error {
+	if len(certs) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	chain := signedChain(certs)
+	if v.roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		chains, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         v.roots,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		})
+		if err != nil {
+			return fmt.Errorf("certificate not trusted by CA bundle: %w", err)
+		}
+		chain = nil
+		for _, verified := range chains {
+			chain = append(chain, verified...)
+		}
+	}
+	if len(v.pins) > 0 {
+		presented := make([]string, 0, len(chain))
+		for i, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range v.pins {
+				if bytes.Equal(sum[:], pin) {
+					if v.roots == nil && i > 0 {
+						return verifyPinnedIssuer(chain[:i+1], serverName)
+					}
+					return nil
+				}
+			}
+			presented = append(presented, spkiHash(cert))
+		}
+		return fmt.Errorf("%w: %s presented %s", ErrCertificatePinMismatch, serverName, strings.Join(presented, ", "))
+	}
+	return nil
+}
+
+// verifyPinnedIssuer checks the leaf against the pinned last certificate of chain as the only root, so a pinned
+// issuer without CA bundle still requires a leaf that is valid now and matches serverName.
+func verifyPinnedIssuer(chain []*x509.Certificate, serverName string) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1 : len(chain)-1] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+	})
+	if err != nil {
+		return fmt.Errorf("certificate not valid for pinned issuer: %w", err)
+	}
+	return nil
+}
+
+// signedChain returns the leaf and the certificates following it as long as each one signed the previous.
+func signedChain(certs []*x509.Certificate) []*x509.Certificate {
+	n := 1
+	for n < len(certs) && certs[n-1].CheckSignatureFrom(certs[n]) == nil {
+		n++
+	}
+	return certs[:n]
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issueCertificate creates certificate for name signed by parent, nil parent makes it self-signed.
+func issueCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestPeerVerifierPins(t *testing.T) {
+	cert, err := generateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := generateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewPeerVerifier([]string{"sha256/" + spkiHash(cert.Leaf)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{cert.Leaf}, "localhost"); err != nil {
+		t.Errorf("expected pinned certificate to pass: %s", err)
+	}
+	err = verifier.Verify([]*x509.Certificate{other.Leaf}, "localhost")
+	if !errors.Is(err, ErrCertificatePinMismatch) {
+		t.Errorf("expected pin mismatch, got %v", err)
+	}
+	if _, err = NewPeerVerifier([]string{"not-a-pin"}, ""); err == nil {
+		t.Error("expected invalid pin error")
+	}
+	if verifier, _ = NewPeerVerifier([]string{""}, ""); verifier != nil {
+		t.Error("expected nil verifier without pins")
+	}
+}
+
+func TestPeerVerifierCABundle(t *testing.T) {
+	cert, err := generateSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewPeerVerifier(nil, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{cert.Leaf}, "localhost"); err != nil {
+		t.Errorf("expected trusted certificate to pass: %s", err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{cert.Leaf}, "example.com"); err == nil {
+		t.Error("expected hostname mismatch")
+	}
+}
+
+func TestPeerVerifierForgedChain(t *testing.T) {
+	ca, caKey := issueCertificate(t, "ca", true, nil, nil)
+	server, _ := issueCertificate(t, "server.example.com", false, ca, caKey)
+	forged, _ := issueCertificate(t, "server.example.com", false, nil, nil)
+	for _, pinned := range []*x509.Certificate{ca, server} {
+		verifier, err := NewPeerVerifier([]string{spkiHash(pinned)}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = verifier.Verify([]*x509.Certificate{server, ca}, "server.example.com"); err != nil {
+			t.Errorf("expected genuine chain to pass pin of %s: %s", pinned.Subject.CommonName, err)
+		}
+		err = verifier.Verify([]*x509.Certificate{forged, server, ca}, "server.example.com")
+		if !errors.Is(err, ErrCertificatePinMismatch) {
+			t.Errorf("expected forged leaf with appended %s to fail, got %v", pinned.Subject.CommonName, err)
+		}
+	}
+}
+
+func TestPeerVerifierPinnedIssuerChecksLeaf(t *testing.T) {
+	ca, caKey := issueCertificate(t, "ca", true, nil, nil)
+	intermediate, intermediateKey := issueCertificate(t, "intermediate", true, ca, caKey)
+	server, _ := issueCertificate(t, "server.example.com", false, intermediate, intermediateKey)
+	verifier, err := NewPeerVerifier([]string{spkiHash(intermediate)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{server, intermediate, ca}, "server.example.com"); err != nil {
+		t.Errorf("expected leaf issued by pinned intermediate to pass: %s", err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{server, intermediate, ca}, "other.example.com"); err == nil {
+		t.Error("expected hostname mismatch with pinned intermediate")
+	}
+	if verifier, err = NewPeerVerifier([]string{spkiHash(server)}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify([]*x509.Certificate{server, intermediate, ca}, "other.example.com"); err != nil {
+		t.Errorf("expected pinned leaf to pass regardless of hostname: %s", err)
+	}
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+)
+
+// raceAttempt is one candidate connection, an endpoint dialed at a specific address.
+type raceAttempt struct {
+	endpoint    *remoteEndpoint
+	dialAddress string
+}
+
+// raceResult is the outcome of a single attempt.
+type raceResult struct {
+	attempt raceAttempt
+	leg     *tunnelLeg
+	err     error
+}
+
+// raceAttempts expands endpoints to one attempt per resolved address, keeping endpoint order.
+// Endpoints that fail to resolve are dialed by host name so the error is reported by the dial.
+func (h *httpClient) raceAttempts(ctx context.Context, endpoints []*remoteEndpoint) []raceAttempt {
+	var attempts []raceAttempt
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint.url)
+		if err != nil {
+			attempts = append(attempts, raceAttempt{endpoint: endpoint})
+			continue
+		}
+		address := hostPort(u)
+		if endpoint.connectAddress != "" {
+			address = endpoint.connectAddress
+		}
+		if proxyURL, _ := h.upstreamProxy.proxyFor(address, secureURL(u)); proxyURL != nil {
+			// The proxy resolves host names, there is a single address to dial.
+			attempts = append(attempts, raceAttempt{endpoint: endpoint})
+			continue
+		}
+		host, port, _ := net.SplitHostPort(address)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			attempts = append(attempts, raceAttempt{endpoint: endpoint})
+			continue
+		}
+		for _, addr := range addrs {
+			attempts = append(attempts, raceAttempt{endpoint: endpoint, dialAddress: net.JoinHostPort(addr.IP.String(), port)})
+		}
+	}
+	return attempts
+}
+
+// raceRemotes dials attempts in parallel staggered by raceDelay, keeps the first completed
+// TLS and web socket handshake and cancels the rest.
+func (h *httpClient) raceRemotes(remoteAddr string, path string) (*tunnelLeg, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-h.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	attempts := h.raceAttempts(ctx, h.remotes.candidates())
+	if len(attempts) == 0 {
+		cancel()
+		return nil, errors.New("no remote to dial")
+	}
+	results := make(chan raceResult, len(attempts))
+	next, running := 0, 0
+	start := func() {
+		attempt := attempts[next]
+		next++
+		running++
+		Logger.Infof("%s - Racing %s at %s", remoteAddr, attempt.endpoint.url, attempt.dialAddress)
+		go func() {
+			leg, err := h.dialEndpoint(ctx, attempt.endpoint, attempt.dialAddress, remoteAddr, path)
+			results <- raceResult{attempt: attempt, leg: leg, err: err}
+		}()
+	}
+	start()
+	failed := map[*remoteEndpoint]bool{}
+	var lastErr error
+	for running > 0 {
+		var stagger <-chan time.Time
+		if next < len(attempts) {
+			stagger = time.After(h.raceDelay)
+		}
+		select {
+		case <-stagger:
+			start()
+		case r := <-results:
+			running--
+			if r.err == nil {
+				cancel()
+				go drainRace(results, running)
+				h.remotes.markHealthy(r.attempt.endpoint)
+				return r.leg, nil
+			}
+			lastErr = r.err
+			failed[r.attempt.endpoint] = true
+			if next < len(attempts) {
+				start()
+			}
+		}
+	}
+	cancel()
+	for endpoint := range failed {
+		h.remotes.markUnhealthy(endpoint)
+	}
+	return nil, lastErr
+}
+
+// drainRace closes legs of attempts that completed after the race was won.
+func drainRace(results chan raceResult, running int) {
+	for i := 0; i < running; i++ {
+		if r := <-results; r.err == nil {
+			r.leg.close()
+		}
+	}
+}
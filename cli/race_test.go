@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRaceRemotes(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7003")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8082", "", "", 1600).Run()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	h := NewHTTPClient("", "", WSTunnel, 1600, func(fd int) {}, nil, false, "", WithRaceDelay(time.Millisecond*50)).(*httpClient)
+	remotes, err := newRemotePool("ws://127.0.0.1:1/tcp/127.0.0.1/7003,ws://localhost:8082/tcp/127.0.0.1/7003", WSTunnel, FailoverOrdered, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.remotes = remotes
+	leg, err := h.dialRemotes("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leg.close()
+	if leg.endpoint != remotes.endpoints[1] || leg.wsConn == nil {
+		t.Error("expected reachable remote to win the race")
+	}
+	if candidates := remotes.candidates(); len(candidates) != 2 {
+		t.Error("remote should not be marked unhealthy when the race is won")
+	}
+}
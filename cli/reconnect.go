@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// errStopped is returned when the client is stopped while waiting to retry.
+var errStopped = errors.New("client stopped")
+
+// ReconnectPolicy
+// controls how remote dial is retried before a newly accepted connection is given up, and how udp flows
+// redial when their web socket drops mid-session. Tcp tunnels are closed when their web socket drops,
+// bytes in flight are lost with it and the stream can not be resumed, so the application reconnects.
+type ReconnectPolicy struct {
+	// InitialDelay is the delay before the first retry, 0 uses 500ms.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// Jitter is the fraction of each delay randomly added or removed, values outside 0 to 1 are clamped.
+	Jitter float64
+	// MaxAttempts is the total number of dial attempts, 1 or less disables retries.
+	MaxAttempts int
+}
+
+// defaultRetryDelay replaces InitialDelay of 0, so retries do not spin.
+const defaultRetryDelay = time.Millisecond * 500
+
+// delay returns wait time before given retry, doubling from InitialDelay up to MaxDelay.
+// Without MaxDelay doubling stops at a quarter of the longest duration, so neither it nor jitter overflows.
+func (p ReconnectPolicy) delay(retry int) time.Duration {
+	d := p.InitialDelay
+	if d <= 0 {
+		d = defaultRetryDelay
+	}
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay) && d <= math.MaxInt64/4; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	if jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * jitter * float64(d))
+	}
+	return d
+}
+
+// WithReconnectPolicy retries remote dial with exponential backoff.
+// onAttempt is called after every attempt with its number and error, nil when it succeeded.
+func WithReconnectPolicy(policy ReconnectPolicy, onAttempt func(attempt int, err error)) ClientOption {
+	return func(h *httpClient) {
+		h.reconnect = policy
+		h.onReconnect = onAttempt
+	}
+}
+
+// dialWithRetry calls dial until it succeeds, attempts run out or client is stopped.
+func (h *httpClient) dialWithRetry(remoteAddr string, dial func() error) error {
+	attempt := 1
+	for {
+		err := dial()
+		if h.onReconnect != nil {
+			h.onReconnect(attempt, err)
+		}
+		if err == nil {
+			return nil
+		}
+		h.counters.dialFailed(err)
+		h.controller.dialFailed(err)
+		if attempt >= h.reconnect.MaxAttempts {
+			return err
+		}
+		delay := h.reconnect.delay(attempt)
+		Logger.Warnf("%s - Dial attempt %d of %d failed, retrying in %s: %s", remoteAddr, attempt, h.reconnect.MaxAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-h.stopped:
+			return errStopped
+		}
+		attempt++
+		atomic.AddInt64(&h.counters.reconnects, 1)
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	policy := ReconnectPolicy{InitialDelay: time.Millisecond * 100, MaxDelay: time.Millisecond * 500}
+	expected := []time.Duration{100, 200, 400, 500, 500}
+	for i, want := range expected {
+		if got := policy.delay(i + 1); got != want*time.Millisecond {
+			t.Errorf("retry %d > got %s want %s", i+1, got, want*time.Millisecond)
+		}
+	}
+	if got := (ReconnectPolicy{MaxAttempts: 3}).delay(2); got != defaultRetryDelay*2 {
+		t.Errorf("missing initial delay should use default, got %s", got)
+	}
+	unbounded := ReconnectPolicy{InitialDelay: time.Second, Jitter: 1}
+	for i := 0; i < 100; i++ {
+		if got := unbounded.delay(200); got < 0 {
+			t.Fatalf("delay without maximum should not overflow, got %s", got)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(1); got < time.Millisecond*50 || got > time.Millisecond*150 {
+			t.Fatalf("jittered delay out of range: %s", got)
+		}
+	}
+	policy.Jitter = 5
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(1); got < 0 || got > time.Millisecond*200 {
+			t.Fatalf("jitter above 1 should be clamped, got %s", got)
+		}
+	}
+}
+
+func TestDialWithRetry(t *testing.T) {
+	var attempts []int
+	h := NewHTTPClient("", "", WSTunnel, 1500, nil, nil, false, "", WithReconnectPolicy(ReconnectPolicy{
+		InitialDelay: time.Millisecond,
+		MaxAttempts:  3,
+	}, func(attempt int, err error) {
+		attempts = append(attempts, attempt)
+	})).(*httpClient)
+	calls := 0
+	err := h.dialWithRetry("test", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 || len(attempts) != 2 {
+		t.Errorf("expected success on second attempt, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = h.dialWithRetry("test", func() error {
+		calls++
+		return errors.New("unreachable")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("expected failure after 3 attempts, got %v after %d calls", err, calls)
+	}
+	close(h.stopped)
+	err = h.dialWithRetry("test", func() error {
+		return errors.New("unreachable")
+	})
+	if !errors.Is(err, errStopped) {
+		t.Errorf("expected stopped error, got %v", err)
+	}
+}
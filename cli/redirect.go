@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Reasons of RedirectError.
+const (
+	RedirectTooMany   = "too many redirects"
+	RedirectLoop      = "redirect loop"
+	RedirectScheme    = "scheme not allowed"
+	RedirectDowngrade = "downgrade from wss to ws"
+	RedirectOtherHost = "other host not allowed"
+	RedirectInvalid   = "invalid location"
+)
+
+// RedirectPolicy
+// limits how 3xx answers to web socket upgrade requests are followed. Location is resolved against the previous url,
+// http and https locations are dialed as ws and wss. Headers are kept, except Host and credentials when host changes.
+type RedirectPolicy struct {
+	// MaxHops is the number of redirects followed for one upgrade, 0 fails on the first redirect.
+	MaxHops int
+	// Schemes lists allowed location schemes after http and https are mapped to ws and wss.
+	Schemes []string
+	// SameHost rejects locations on a host other than the remote url host.
+	SameHost bool
+	// AllowDowngrade follows redirects from wss to ws.
+	AllowDowngrade bool
+}
+
+// DefaultRedirectPolicy follows up to 5 redirects to ws or wss urls on any host without downgrading.
+func DefaultRedirectPolicy() RedirectPolicy {
+	return RedirectPolicy{MaxHops: 5, Schemes: []string{"ws", "wss"}}
+}
+
+// RedirectError
+// is returned when a redirect violates RedirectPolicy, it unwraps to websocket.ErrBadHandshake.
+type RedirectError struct {
+	From     string
+	Location string
+	Hops     int
+	Reason   string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect from %s to %q after %d hops: %s", e.From, e.Location, e.Hops, e.Reason)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return websocket.ErrBadHandshake
+}
+
+// WithRedirectPolicy replaces DefaultRedirectPolicy.
+func WithRedirectPolicy(policy RedirectPolicy) ClientOption {
+	return func(h *httpClient) {
+		h.redirects = policy
+	}
+}
+
+// redirectTracker follows redirects of one upgrade request.
+type redirectTracker struct {
+	policy  RedirectPolicy
+	origin  *url.URL
+	hops    int
+	visited map[string]bool
+}
+
+func newRedirectTracker(policy RedirectPolicy, remote string) *redirectTracker {
+	t := &redirectTracker{policy: policy, visited: make(map[string]bool)}
+	if u, err := url.Parse(remote); err == nil {
+		t.origin = wsScheme(u)
+		t.visited[t.origin.String()] = true
+	}
+	return t
+}
+
+// next checks location answered for from and returns the absolute url to dial next.
+func (t *redirectTracker) next(from string, location string) (string, error) {
+	fail := func(reason string) (string, error) {
+		return "", &RedirectError{From: from, Location: location, Hops: t.hops, Reason: reason}
+	}
+	if t.hops >= t.policy.MaxHops {
+		return fail(RedirectTooMany)
+	}
+	fromURL, err := url.Parse(from)
+	if err != nil || location == "" {
+		return fail(RedirectInvalid)
+	}
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return fail(RedirectInvalid)
+	}
+	fromURL = wsScheme(fromURL)
+	next := wsScheme(fromURL.ResolveReference(locationURL))
+	allowed := false
+	for _, scheme := range t.policy.Schemes {
+		allowed = allowed || strings.EqualFold(scheme, next.Scheme)
+	}
+	switch {
+	case !allowed:
+		return fail(RedirectScheme)
+	case fromURL.Scheme == "wss" && next.Scheme == "ws" && !t.policy.AllowDowngrade:
+		return fail(RedirectDowngrade)
+	case t.policy.SameHost && t.origin != nil && !strings.EqualFold(next.Hostname(), t.origin.Hostname()):
+		return fail(RedirectOtherHost)
+	case t.visited[next.String()]:
+		return fail(RedirectLoop)
+	}
+	t.visited[next.String()] = true
+	t.hops++
+	return next.String(), nil
+}
+
+// wsScheme maps http and https urls to ws and wss.
+func wsScheme(u *url.URL) *url.URL {
+	mapped := *u
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		mapped.Scheme = "ws"
+	case "https":
+		mapped.Scheme = "wss"
+	default:
+		mapped.Scheme = strings.ToLower(u.Scheme)
+	}
+	return &mapped
+}
+
+// isRedirect reports whether status asks to repeat the upgrade request at Location.
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectTracker(t *testing.T) {
+	policy := DefaultRedirectPolicy()
+	tracker := newRedirectTracker(policy, "wss://a.example.com/tcp/1/1")
+	next, err := tracker.next("wss://a.example.com/tcp/1/1", "/tcp/2/2")
+	if err != nil || next != "wss://a.example.com/tcp/2/2" {
+		t.Errorf("relative location should resolve against previous url, got %s %v", next, err)
+	}
+	next, err = tracker.next(next, "https://b.example.com/tcp/1/1")
+	if err != nil || next != "wss://b.example.com/tcp/1/1" {
+		t.Errorf("https location should map to wss, got %s %v", next, err)
+	}
+	sameHost := policy
+	sameHost.SameHost = true
+	cases := []struct {
+		policy   RedirectPolicy
+		location string
+		reason   string
+	}{
+		{policy, "ftp://a.example.com/", RedirectScheme},
+		{policy, "http://a.example.com/tcp/2/2", RedirectDowngrade},
+		{policy, "/tcp/1/1", RedirectLoop},
+		{policy, "", RedirectInvalid},
+		{sameHost, "wss://b.example.com/tcp/1/1", RedirectOtherHost},
+		{RedirectPolicy{Schemes: []string{"wss"}}, "/tcp/2/2", RedirectTooMany},
+	}
+	for _, c := range cases {
+		_, err := newRedirectTracker(c.policy, "wss://a.example.com/tcp/1/1").next("wss://a.example.com/tcp/1/1", c.location)
+		var redirectErr *RedirectError
+		if !errors.As(err, &redirectErr) || redirectErr.Reason != c.reason || !errors.Is(err, websocket.ErrBadHandshake) {
+			t.Errorf("%q: expected %s, got %v", c.location, c.reason, err)
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/old/"):
+			http.Redirect(w, r, "../tcp/127.0.0.1/1", http.StatusFound)
+		case r.URL.Path == "/loop/a":
+			http.Redirect(w, r, "/loop/b", http.StatusTemporaryRedirect)
+		case r.URL.Path == "/loop/b":
+			http.Redirect(w, r, "/loop/a", http.StatusTemporaryRedirect)
+		default:
+			if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+				_ = conn.Close()
+			}
+		}
+	}))
+	defer server.Close()
+	remote := "ws" + strings.TrimPrefix(server.URL, "http")
+	h := NewHTTPClient("", remote, WSTunnel, 1600, func(fd int) {}, nil, false, "").(*httpClient)
+	conn, err := h.createWsConnection(context.Background(), "test", remote+"/old/x", "", nil, "")
+	if err != nil {
+		t.Fatalf("relative redirect should be followed: %v", err)
+	}
+	_ = conn.Close()
+	_, err = h.createWsConnection(context.Background(), "test", remote+"/loop/a", "", nil, "")
+	var redirectErr *RedirectError
+	if !errors.As(err, &redirectErr) || redirectErr.Reason != RedirectLoop || redirectErr.Hops != 1 {
+		t.Errorf("expected redirect loop error, got %v", err)
+	}
+}
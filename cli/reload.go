@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"errors"
+)
+
+// reloadFunc builds and applies the next settings generation, see Controller.Reload.
+type reloadFunc func(listenTCP, remoteServer string, tunnelType int, mtu int, extraPadding bool, tlsServerName string, options []ClientOption) error
+
+// reload validates new settings and makes them current, established tunnels keep the generation they started with.
+// A changed tcp listen address is bound before the old listener is closed, reverse control channel is redialed.
+// A changed udp listen address is bound before new flows move to it, open flows keep answering from the old socket.
+func (r *clientRuntime) reload(listenTCP, remoteServer string, tunnelType int, mtu int, extraPadding bool, tlsServerName string, options []ClientOption) error {
+	next := r.newGeneration(listenTCP, remoteServer, tunnelType, mtu, extraPadding, tlsServerName, options)
+	if err := next.prepare(); err != nil {
+		return err
+	}
+	r.genMu.Lock()
+	defer r.genMu.Unlock()
+	select {
+	case <-r.stopped:
+		return errStopped
+	default:
+	}
+	current := r.gen
+	if next.reverse != current.reverse || next.remotes.udp() != current.remotes.udp() {
+		return errors.New("reload can not switch between tcp, udp and reverse forwarding, restart the client instead")
+	}
+	if next.listenTCP != current.listenTCP && !next.reverse {
+		if r.udpListener != nil {
+			listener, err := bindUDP(next.listenTCP)
+			if err != nil {
+				return err
+			}
+			r.udpListener.retire()
+			r.udpListener = listener
+			go r.readUDP(listener)
+		}
+		if r.listener != nil {
+			listener, err := bindTCP(next.listenTCP)
+			if err != nil {
+				return err
+			}
+			_ = r.listener.Close()
+			r.listener = listener
+		}
+	}
+	if current.multiplex {
+		// Streams of the old mux session keep running, it is closed with the client.
+		r.retired = append(r.retired, current)
+	}
+	r.gen = next
+	if r.control != nil {
+		r.control.restart()
+	}
+	Logger.Infof("Settings reloaded, new connections use %s", remoteServer)
+	return nil
+}
+
+// closeMuxes closes mux sessions of the current and replaced generations.
+func (r *clientRuntime) closeMuxes() {
+	r.genMu.Lock()
+	generations := append([]*httpClient{r.gen}, r.retired...)
+	r.retired = nil
+	r.genMu.Unlock()
+	for _, g := range generations {
+		g.closeMux()
+	}
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsOpenTunnels(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7012")
+	greeter, err := net.Listen("tcp", "127.0.0.1:7013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer greeter.Close()
+	go func() {
+		for {
+			conn, err := greeter.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("B"))
+			_ = conn.Close()
+		}
+	}()
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8092", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	events, cancel := controller.Subscribe()
+	defer cancel()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1209", "ws://127.0.0.1:8092/tcp/127.0.0.1/7012", WSTunnel, 1600, func(fd int) {}, controller, false, "").Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	open, err := net.Dial("tcp", "127.0.0.1:1209")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	_ = open.SetDeadline(time.Now().Add(time.Second * 5))
+	received := make([]byte, 4)
+	_, _ = open.Write([]byte("ping"))
+	if _, err = io.ReadFull(open, received); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = controller.Reload("127.0.0.1:1209", "ws://127.0.0.1:8092/udp/127.0.0.1/7012", WSTunnel, 1600, false, ""); err == nil {
+		t.Error("reload should not switch to udp remotes")
+	}
+	if err = controller.Reload("127.0.0.1:1210", "ws://127.0.0.1:8092/tcp/127.0.0.1/7013", WSTunnel, 1600, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if status := controller.Status(); status.ListenAddress != "127.0.0.1:1210" {
+		t.Errorf("unexpected listen address %s", status.ListenAddress)
+	}
+
+	_, _ = open.Write([]byte("pong"))
+	if _, err = io.ReadFull(open, received); err != nil || string(received) != "pong" {
+		t.Errorf("tunnel opened before reload should keep working, got %q %v", received, err)
+	}
+	if _, err = net.DialTimeout("tcp", "127.0.0.1:1209", time.Second); err == nil {
+		t.Error("old listen address should be closed")
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:1210")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	greeting := make([]byte, 1)
+	if _, err = io.ReadFull(conn, greeting); err != nil || string(greeting) != "B" {
+		t.Errorf("new connection should use reloaded remote, got %q %v", greeting, err)
+	}
+
+	reloaded := false
+	for !reloaded {
+		select {
+		case event := <-events:
+			reloaded = event.Type == EventReloaded && event.Message == "127.0.0.1:1210"
+		case <-time.After(time.Second):
+			t.Fatal("reloaded event not published")
+		}
+	}
+}
+
+func TestReloadBeforeStart(t *testing.T) {
+	if err := NewController().Reload(":1", "ws://127.0.0.1:1/tcp/127.0.0.1/1", WSTunnel, 1600, false, ""); err == nil {
+		t.Error("reload of client that is not running should fail")
+	}
+}
+
+func TestReloadMovesUDPListener(t *testing.T) {
+	startUdpEchoServer(t, "127.0.0.1:7018")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8099", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	remote := "ws://127.0.0.1:8099/udp/127.0.0.1/7018"
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1223", remote, WSTunnel, 1600, func(fd int) {}, controller, false, "", WithUDPIdleTimeout(time.Millisecond*400)).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	echo := func(conn net.Conn, payload string) error {
+		received := make([]byte, 16)
+		var err error
+		// First datagram of a flow is queued while its web socket is dialed.
+		for i := 0; i < 3; i++ {
+			_, _ = conn.Write([]byte(payload))
+			_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+			var readSize int
+			if readSize, err = conn.Read(received); err == nil && string(received[:readSize]) == payload {
+				return nil
+			}
+		}
+		return err
+	}
+	open, err := net.Dial("udp", "127.0.0.1:1223")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	if err = echo(open, "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = controller.Reload("127.0.0.1:1224", remote, WSTunnel, 1600, false, "", WithUDPIdleTimeout(time.Millisecond*400)); err != nil {
+		t.Fatal(err)
+	}
+	if err = echo(open, "pong"); err != nil {
+		t.Errorf("flow opened before reload should keep working, got %v", err)
+	}
+	moved, err := net.Dial("udp", "127.0.0.1:1224")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer moved.Close()
+	if err = echo(moved, "ping"); err != nil {
+		t.Errorf("new flow should use reloaded listen address, got %v", err)
+	}
+	time.Sleep(time.Second)
+	old, err := net.ListenPacket("udp", "127.0.0.1:1223")
+	if err != nil {
+		t.Fatalf("old udp listen address should be closed after its flows expired, got %v", err)
+	}
+	_ = old.Close()
+}
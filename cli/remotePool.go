@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FailoverOrdered always tries remotes in configured order.
+	FailoverOrdered = "ordered"
+	// FailoverLastGood starts with the remote that connected last and continues in configured order.
+	FailoverLastGood = "lastGood"
+)
+
+// remoteEndpoint is one remote server, its tunnel type follows the url scheme unless one was set explicitly.
+type remoteEndpoint struct {
+	url            string
+	tunnelType     int
+	unhealthyUntil time.Time
+	// headers are sent with web socket upgrade requests.
+	headers http.Header
+	// connectAddress and sni replace url host:port for dialing and TLS server name when set.
+	connectAddress string
+	sni            string
+}
+
+// remotePool
+// orders remote endpoints for dialing and keeps failing ones aside for a cooldown period.
+type remotePool struct {
+	mu        sync.Mutex
+	endpoints []*remoteEndpoint
+	strategy  string
+	cooldown  time.Duration
+	lastGood  int
+}
+
+// newRemotePool parses comma separated remotes. With the default WSTunnel type the url scheme selects the tunnel
+// type, https:// uses Stunnel and wss:// or ws:// with /udp/ path use UDPTunnel. An explicit Stunnel or UDPTunnel
+// type applies to all remotes, urls of the other protocol are rejected.
+func newRemotePool(remotes string, defaultTunnelType int, strategy string, cooldown time.Duration) (*remotePool, error) {
+	if strategy == "" {
+		strategy = FailoverOrdered
+	}
+	if strategy != FailoverOrdered && strategy != FailoverLastGood {
+		return nil, fmt.Errorf("invalid failover strategy %q, expected %s or %s", strategy, FailoverOrdered, FailoverLastGood)
+	}
+	p := &remotePool{strategy: strategy, cooldown: cooldown}
+	for _, remote := range strings.Split(remotes, ",") {
+		remote = strings.TrimSpace(remote)
+		if remote == "" {
+			continue
+		}
+		u, err := url.Parse(remote)
+		if err != nil {
+			return nil, err
+		}
+		webSocket := u.Scheme == "ws" || u.Scheme == "wss"
+		tunnelType := defaultTunnelType
+		switch {
+		case defaultTunnelType == Stunnel && webSocket:
+			return nil, fmt.Errorf("remote %s is a web socket url, Stunnel tunnel type needs https://", remote)
+		case defaultTunnelType == UDPTunnel && u.Scheme == "https":
+			return nil, fmt.Errorf("remote %s is a Stunnel url, UDPTunnel tunnel type needs ws:// or wss://", remote)
+		case defaultTunnelType != WSTunnel:
+			// Explicit tunnel type is kept.
+		case webSocket && strings.HasPrefix(u.Path, UdpPathPrefix):
+			tunnelType = UDPTunnel
+		case u.Scheme == "https":
+			tunnelType = Stunnel
+		}
+		if tunnelType != WSTunnel && tunnelType != Stunnel && tunnelType != UDPTunnel {
+			return nil, fmt.Errorf("invalid tunnel type specified for %s", remote)
+		}
+		p.endpoints = append(p.endpoints, &remoteEndpoint{url: remote, tunnelType: tunnelType})
+	}
+	if len(p.endpoints) == 0 {
+		return nil, fmt.Errorf("no remote address specified")
+	}
+	for _, e := range p.endpoints {
+		if (e.tunnelType == UDPTunnel) != p.udp() {
+			return nil, fmt.Errorf("remote %s can not be mixed with %s, udp and tcp remotes must be configured separately", e.url, p.endpoints[0].url)
+		}
+	}
+	return p, nil
+}
+
+// udp reports whether remotes carry udp datagrams instead of tcp streams.
+func (p *remotePool) udp() bool {
+	return p.endpoints[0].tunnelType == UDPTunnel
+}
+
+// candidates returns endpoints to try in order, skipping unhealthy ones unless all of them are.
+func (p *remotePool) candidates() []*remoteEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := 0
+	if p.strategy == FailoverLastGood {
+		start = p.lastGood
+	}
+	now := time.Now()
+	ordered := make([]*remoteEndpoint, 0, len(p.endpoints))
+	healthy := make([]*remoteEndpoint, 0, len(p.endpoints))
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		ordered = append(ordered, e)
+		if now.After(e.unhealthyUntil) {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		return ordered
+	}
+	return healthy
+}
+
+// markHealthy clears cooldown and remembers endpoint for FailoverLastGood.
+func (p *remotePool) markHealthy(e *remoteEndpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.unhealthyUntil = time.Time{}
+	for i, endpoint := range p.endpoints {
+		if endpoint == e {
+			p.lastGood = i
+		}
+	}
+}
+
+// markUnhealthy skips endpoint until cooldown expires.
+func (p *remotePool) markUnhealthy(e *remoteEndpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.unhealthyUntil = time.Now().Add(p.cooldown)
+	Logger.Warnf("Remote %s marked unhealthy for %s", e.url, p.cooldown)
+}
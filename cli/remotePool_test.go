@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemotePoolFailover(t *testing.T) {
+	pool, err := newRemotePool("wss://a/tcp/127.0.0.1/1194, https://b,wss://c/tcp/127.0.0.1/1194", WSTunnel, FailoverOrdered, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.endpoints[1].tunnelType != Stunnel || pool.endpoints[2].tunnelType != WSTunnel {
+		t.Error("tunnel type should follow url scheme")
+	}
+	a, b, c := pool.endpoints[0], pool.endpoints[1], pool.endpoints[2]
+	pool.markUnhealthy(a)
+	if candidates := pool.candidates(); len(candidates) != 2 || candidates[0] != b {
+		t.Errorf("unhealthy remote should be skipped, got %d candidates", len(candidates))
+	}
+	pool.markHealthy(c)
+	if candidates := pool.candidates(); candidates[0] != b {
+		t.Error("ordered strategy should keep configured order")
+	}
+	pool.markUnhealthy(b)
+	pool.markUnhealthy(c)
+	if candidates := pool.candidates(); len(candidates) != 3 {
+		t.Error("all remotes should be tried when none is healthy")
+	}
+
+	pool, _ = newRemotePool("wss://a,wss://b,wss://c", WSTunnel, FailoverLastGood, time.Minute)
+	pool.markHealthy(pool.endpoints[1])
+	if candidates := pool.candidates(); candidates[0] != pool.endpoints[1] || candidates[2] != pool.endpoints[0] {
+		t.Error("lastGood strategy should start with last good remote")
+	}
+
+	if _, err = newRemotePool("", WSTunnel, FailoverOrdered, 0); err == nil {
+		t.Error("expected error without remotes")
+	}
+	if _, err = newRemotePool("tcp://a", 5, FailoverOrdered, 0); err == nil {
+		t.Error("expected invalid tunnel type error")
+	}
+	if pool, err = newRemotePool("wss://a/udp/127.0.0.1/1194,wss://b", UDPTunnel, FailoverOrdered, 0); err != nil || !pool.udp() {
+		t.Error("udp remotes should use udp tunnel type")
+	}
+	if _, err = newRemotePool("wss://a/udp/127.0.0.1/1194,wss://b/tcp/127.0.0.1/1194", WSTunnel, FailoverOrdered, 0); err == nil {
+		t.Error("expected error when mixing udp and tcp remotes")
+	}
+	if _, err = newRemotePool("wss://a", WSTunnel, "random", 0); err == nil {
+		t.Error("expected invalid strategy error")
+	}
+}
+
+func TestRemotePoolTunnelType(t *testing.T) {
+	cases := []struct {
+		remotes    string
+		tunnelType int
+		expected   int
+	}{
+		{"https://a", WSTunnel, Stunnel},
+		{"wss://a/udp/127.0.0.1/1194", WSTunnel, UDPTunnel},
+		{"wss://a/tcp/127.0.0.1/1194", WSTunnel, WSTunnel},
+		{"https://a", Stunnel, Stunnel},
+		{"a:443", Stunnel, Stunnel},
+		{"wss://a/tcp/127.0.0.1/1194", UDPTunnel, UDPTunnel},
+	}
+	for _, c := range cases {
+		pool, err := newRemotePool(c.remotes, c.tunnelType, FailoverOrdered, 0)
+		if err != nil {
+			t.Errorf("%s with tunnel type %d: %s", c.remotes, c.tunnelType, err)
+		} else if pool.endpoints[0].tunnelType != c.expected {
+			t.Errorf("%s with tunnel type %d should use %d, got %d", c.remotes, c.tunnelType, c.expected, pool.endpoints[0].tunnelType)
+		}
+	}
+	if _, err := newRemotePool("wss://a/tcp/127.0.0.1/1194", Stunnel, FailoverOrdered, 0); err == nil {
+		t.Error("web socket url should conflict with Stunnel tunnel type")
+	}
+	if _, err := newRemotePool("https://a,https://b", UDPTunnel, FailoverOrdered, 0); err == nil {
+		t.Error("https url should conflict with UDPTunnel tunnel type")
+	}
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithReverse exposes the listen address through the remote server instead of listening on it.
+// Remotes must use ReversePathPrefix paths naming the server side bind address, the server dials back
+// over a new web socket for each connection it accepts and the client bridges it to the listen address.
+func WithReverse(enabled bool) ClientOption {
+	return func(h *httpClient) {
+		h.reverse = enabled
+	}
+}
+
+// reverseControl holds the current control web socket so stopping the client can close it.
+type reverseControl struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	closed bool
+}
+
+// set replaces current control connection, returns false when client was stopped meanwhile.
+func (c *reverseControl) set(conn *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return false
+	}
+	c.conn = conn
+	return true
+}
+
+// restart closes current control connection so it is redialed with reloaded settings.
+func (c *reverseControl) restart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+func (c *reverseControl) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// prepareReverse checks remotes name server side bind address and replaces settings reverse forwarding does not support.
+func (h *httpClient) prepareReverse() error {
+	for _, endpoint := range h.remotes.endpoints {
+		u, err := url.Parse(endpoint.url)
+		if err != nil || endpoint.tunnelType != WSTunnel || !strings.HasPrefix(u.Path, ReversePathPrefix) {
+			err = fmt.Errorf("reverse remote %s must be web socket url with %s<host>/<port> path", endpoint.url, ReversePathPrefix)
+			Logger.Errorf("Invalid remote address: %s", err)
+			return err
+		}
+	}
+	if h.multiplex {
+		Logger.Warnf("Multiplexing is not supported for reverse forwarding, using one web socket per connection.")
+		h.multiplex = false
+	}
+	return nil
+}
+
+// runReverse keeps a control web socket open, reconnecting after it drops, until the client is stopped.
+// Every control channel uses the settings generation current when it was dialed.
+func (h *httpClient) runReverse() error {
+	control := &reverseControl{}
+	h.genMu.Lock()
+	h.control = control
+	h.genMu.Unlock()
+	isDone := h.watchStop(control)
+	Logger.Infof("Forwarding reverse connections to %s", h.listenTCP)
+	for !isDone() {
+		g := h.generation()
+		var leg *tunnelLeg
+		err := g.dialWithRetry(g.remoteServer, func() error {
+			var err error
+			leg, err = g.dialRemotes(g.remoteServer, "")
+			return err
+		})
+		if err == nil && control.set(leg.wsConn) {
+			Logger.Infof("Reverse control channel established with %s", leg.endpoint.url)
+			g.serveReverse(leg)
+			Logger.Warnf("Reverse control channel with %s closed", leg.endpoint.url)
+		} else if err != nil && err != errStopped {
+			Logger.Errorf("Remote server connection > Error while dialing %s: %s", g.remoteServer, err)
+		}
+		select {
+		case <-h.stopped:
+		case <-time.After(g.reverseRedialDelay()):
+		}
+	}
+	return nil
+}
+
+// reverseRedialDelay is the pause before control channel is dialed again.
+func (h *httpClient) reverseRedialDelay() time.Duration {
+	if d := h.reconnect.delay(1); d > time.Second {
+		return d
+	}
+	return time.Second
+}
+
+// serveReverse opens data channel for every id announced on control channel until it closes.
+func (h *httpClient) serveReverse(leg *tunnelLeg) {
+	control := leg.wsConn
+	defer control.Close()
+	done := make(chan struct{})
+	defer close(done)
+	if h.pingInterval > 0 {
+		control.SetPongHandler(func(string) error {
+			return control.SetReadDeadline(time.Now().Add(h.pingInterval + h.pongTimeout))
+		})
+		go func() {
+			ticker := time.NewTicker(h.pingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := control.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.pongTimeout)); err != nil {
+						_ = control.Close()
+						return
+					}
+				}
+			}
+		}()
+	}
+	for {
+		if h.pingInterval > 0 {
+			_ = control.SetReadDeadline(time.Now().Add(h.pingInterval + h.pongTimeout))
+		}
+		messageType, message, err := control.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.TextMessage {
+			go h.openReverseData(leg.endpoint, string(message))
+		}
+	}
+}
+
+// openReverseData dials the listen address and bridges it to a new data channel for id.
+func (h *httpClient) openReverseData(endpoint *remoteEndpoint, id string) {
+	if h.controller.paused() {
+		Logger.Infof("Paused, ignoring reverse connection %s", id)
+		return
+	}
+	localConn, err := net.DialTimeout("tcp", h.listenTCP, time.Second*10)
+	if err != nil {
+		Logger.Errorf("Reverse connection %s > Error while dialing %s: %s", id, h.listenTCP, err)
+		return
+	}
+	leg, err := h.dialEndpoint(context.Background(), endpoint, "", localConn.LocalAddr().String(), ReverseDataPathPrefix+id)
+	if err != nil {
+		Logger.Errorf("Reverse connection %s > Error while opening data channel: %s", id, err)
+		_ = localConn.Close()
+		return
+	}
+	remoteAddr := localConn.LocalAddr().String()
+	h.controller.connectionOpened(remoteAddr)
+	defer h.controller.connectionClosed(remoteAddr)
+	h.runBridge(NewBidirConnection(localConn, leg.wsConn, time.Second*10, h.mtu, h.pingInterval, h.pongTimeout))
+}
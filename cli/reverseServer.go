@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ReversePathPrefix is the control request path prefix used by clients to select the server side bind address.
+const ReversePathPrefix = "/reverse/"
+
+// ReverseDataPathPrefix is the request path prefix of data channels, followed by the id announced on control channel.
+const ReverseDataPathPrefix = "/reversedata/"
+
+// reversePendingTimeout is how long an accepted connection waits for the client to open its data channel.
+const reversePendingTimeout = time.Second * 10
+
+// WithReverseForwarding lets clients open listeners on the server and receive their connections, like ssh -R.
+func WithReverseForwarding(allowed bool) ServerOption {
+	return func(s *wsTunnelServer) {
+		s.allowReverse = allowed
+	}
+}
+
+// handleReverseControl listens on the address from request path while the control web socket is open
+// and announces every accepted connection to the client by a random id.
+func (s *wsTunnelServer) handleReverseControl(w http.ResponseWriter, r *http.Request) {
+	if !s.allowReverse {
+		Logger.Errorf("%s - Reverse forwarding requested but not allowed", r.RemoteAddr)
+		http.Error(w, "Reverse forwarding is disabled.", http.StatusForbidden)
+		return
+	}
+	bindAddress, err := parseTargetPath(r.URL.Path, ReversePathPrefix)
+	if err != nil {
+		Logger.Errorf("%s - Invalid tunnel path %s: %s", r.RemoteAddr, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	listener, err := net.Listen("tcp", bindAddress)
+	if err != nil {
+		Logger.Errorf("%s - Error while listening on %s: %s", r.RemoteAddr, bindAddress, err)
+		http.Error(w, "Unable to listen on requested address.", http.StatusServiceUnavailable)
+		return
+	}
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Errorf("%s - Upgrade failed: %s", r.RemoteAddr, err)
+		_ = listener.Close()
+		return
+	}
+	Logger.Infof("%s - Reverse listener opened on %s", r.RemoteAddr, bindAddress)
+	go func() {
+		// Client sends nothing but pings, a read error means control channel is gone.
+		for {
+			if _, _, err := wsConn.NextReader(); err != nil {
+				_ = listener.Close()
+				return
+			}
+		}
+	}()
+	for {
+		tcpConn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		id := s.addPending(tcpConn)
+		if err = wsConn.WriteMessage(websocket.TextMessage, []byte(id)); err != nil {
+			break
+		}
+	}
+	_ = listener.Close()
+	_ = wsConn.Close()
+	Logger.Infof("%s - Reverse listener closed on %s", r.RemoteAddr, bindAddress)
+}
+
+// handleReverseData bridges data channel to the accepted connection waiting under id from request path.
+func (s *wsTunnelServer) handleReverseData(w http.ResponseWriter, r *http.Request) {
+	tcpConn := s.takePending(strings.TrimPrefix(r.URL.Path, ReverseDataPathPrefix))
+	if tcpConn == nil {
+		http.Error(w, "Unknown reverse connection.", http.StatusNotFound)
+		return
+	}
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Errorf("%s - Upgrade failed: %s", r.RemoteAddr, err)
+		_ = tcpConn.Close()
+		return
+	}
+	Logger.Infof("%s - Reverse connection from %s opened", r.RemoteAddr, tcpConn.RemoteAddr())
+	b := NewBidirConnection(tcpConn, wsConn, time.Second*10, s.mtu, 0, 0)
+	_ = b.Run()
+	Logger.Infof("%s - Reverse connection from %s closed", r.RemoteAddr, tcpConn.RemoteAddr())
+}
+
+// addPending keeps connection until its data channel arrives or reversePendingTimeout passes.
+func (s *wsTunnelServer) addPending(tcpConn net.Conn) string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+	s.reverseMu.Lock()
+	s.pending[id] = tcpConn
+	s.reverseMu.Unlock()
+	time.AfterFunc(reversePendingTimeout, func() {
+		if conn := s.takePending(id); conn != nil {
+			Logger.Warnf("Reverse connection from %s got no data channel, closing.", conn.RemoteAddr())
+			_ = conn.Close()
+		}
+	})
+	return id
+}
+
+// takePending removes and returns connection waiting under id.
+func (s *wsTunnelServer) takePending(id string) net.Conn {
+	s.reverseMu.Lock()
+	defer s.reverseMu.Unlock()
+	conn := s.pending[id]
+	delete(s.pending, id)
+	return conn
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseForwarding(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7008")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8088", "", "", 1600, WithReverseForwarding(true)).Run()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:7008", "ws://127.0.0.1:8088/reverse/127.0.0.1/1201", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithReverse(true)).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:1201")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+		_, _ = conn.Write([]byte("reverse"))
+		received := make([]byte, 7)
+		if _, err = io.ReadFull(conn, received); err != nil || string(received) != "reverse" {
+			t.Errorf("echo mismatch: %q %v", received, err)
+		}
+		_ = conn.Close()
+	}
+	_, _ = controller.Stop(time.Second)
+	time.Sleep(time.Millisecond * 200)
+	if conn, err := net.Dial("tcp", "127.0.0.1:1201"); err == nil {
+		_ = conn.Close()
+		t.Error("server listener should be closed with the control channel")
+	}
+}
+
+func TestReverseForwardingDisabled(t *testing.T) {
+	s := NewWsTunnelServer("", "", "", 1600).(*wsTunnelServer)
+	server := httptest.NewServer(http.HandlerFunc(s.handleReverseControl))
+	defer server.Close()
+	_, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/reverse/127.0.0.1/1202", nil)
+	if err == nil || response.StatusCode != http.StatusForbidden {
+		t.Error("reverse forwarding should be refused unless allowed")
+	}
+}
@@ -0,0 +1,301 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929.
+const (
+	socksVersion          = 5
+	socksAuthVersion      = 1
+	socksMethodNoAuth     = 0x00
+	socksMethodUserPass   = 0x02
+	socksMethodNoneFound  = 0xff
+	socksCommandConnect   = 0x01
+	socksCommandAssociate = 0x03
+	socksAddrIPv4         = 0x01
+	socksAddrDomain       = 0x03
+	socksAddrIPv6         = 0x04
+	socksSucceeded        = 0x00
+	socksGeneralFailure   = 0x01
+	socksHostUnreachable  = 0x04
+	socksCommandRejected  = 0x07
+	socksAddrRejected     = 0x08
+)
+
+// errSocksAddressType is returned for address types other than IPv4, IPv6 and domain name.
+var errSocksAddressType = errors.New("unsupported socks address type")
+
+// WithSOCKS5 turns the local listener into a SOCKS5 proxy, requested destinations are reached through
+// the web socket remotes. Username and password authentication is required when username is set.
+func WithSOCKS5(username string, password string) ClientOption {
+	return func(h *httpClient) {
+		h.socks = true
+		h.socksUsername = username
+		h.socksPassword = password
+	}
+}
+
+// handleSocksConnection negotiates SOCKS5 and serves CONNECT or UDP ASSOCIATE request of the local connection.
+func (h *httpClient) handleSocksConnection(localConn net.Conn) {
+	_ = localConn.SetDeadline(time.Now().Add(time.Second * 10))
+	command, target, err := socksHandshake(localConn, h.socksUsername, h.socksPassword)
+	if err != nil {
+		Logger.Errorf("%s - SOCKS5 handshake failed: %s", localConn.RemoteAddr(), err)
+		_ = localConn.Close()
+		return
+	}
+	switch command {
+	case socksCommandConnect:
+		h.socksConnect(localConn, target)
+	case socksCommandAssociate:
+		h.socksAssociate(localConn)
+	default:
+		_ = writeSocksReply(localConn, socksCommandRejected, nil)
+		_ = localConn.Close()
+	}
+}
+
+// socksConnect opens a tcp leg to target and bridges it to the local connection.
+func (h *httpClient) socksConnect(localConn net.Conn, target string) {
+	remoteAddr := localConn.RemoteAddr().String()
+	Logger.Infof("%s - SOCKS5 connect to %s", remoteAddr, target)
+	var leg *tunnelLeg
+	err := h.dialWithRetry(remoteAddr, func() error {
+		var err error
+		leg, err = h.openLeg(remoteAddr, target)
+		return err
+	})
+	if err != nil {
+		Logger.Errorf("%s - Remote server connection > Error while dialing %s for %s: %s", remoteAddr, h.remoteServer, target, err)
+		_ = writeSocksReply(localConn, socksHostUnreachable, nil)
+		_ = localConn.Close()
+		return
+	}
+	if err = writeSocksReply(localConn, socksSucceeded, nil); err != nil {
+		leg.close()
+		_ = localConn.Close()
+		return
+	}
+	_ = localConn.SetDeadline(time.Time{})
+	h.runBridge(h.bridge(leg, localConn))
+}
+
+// socksAssociate relays datagrams of the local client, every destination gets its own udp flow.
+// The association ends when the control connection is closed.
+func (h *httpClient) socksAssociate(control net.Conn) {
+	remoteAddr := control.RemoteAddr().String()
+	localIP := control.LocalAddr().(*net.TCPAddr).IP
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		Logger.Errorf("%s - SOCKS5 udp relay failed: %s", remoteAddr, err)
+		_ = writeSocksReply(control, socksGeneralFailure, nil)
+		_ = control.Close()
+		return
+	}
+	if err = writeSocksReply(control, socksSucceeded, relay.LocalAddr()); err != nil {
+		_ = relay.Close()
+		_ = control.Close()
+		return
+	}
+	Logger.Infof("%s - SOCKS5 udp association on %s", remoteAddr, relay.LocalAddr())
+	_ = control.SetDeadline(time.Time{})
+	flows := newUDPFlowTable()
+	go func() {
+		_, _ = io.Copy(io.Discard, control)
+		_ = relay.Close()
+	}()
+	defer flows.closeAll()
+	defer control.Close()
+	clientIP := control.RemoteAddr().(*net.TCPAddr).IP
+	var clientAddr *net.UDPAddr
+	data := make([]byte, maxDatagramSize)
+	for {
+		readSize, from, err := relay.ReadFromUDP(data)
+		if err != nil {
+			break
+		}
+		// Only the client owning the control connection may use the relay, its first datagram fixes the port.
+		if !from.IP.Equal(clientIP) || (clientAddr != nil && from.Port != clientAddr.Port) {
+			continue
+		}
+		clientAddr = from
+		target, payload, err := parseSocksDatagram(data[:readSize])
+		if err != nil {
+			Logger.Warnf("%s - Dropping SOCKS5 datagram: %s", remoteAddr, err)
+			continue
+		}
+		packet := make([]byte, len(payload))
+		copy(packet, payload)
+		flow, created := flows.get(target, func() *udpFlow {
+			return newUDPFlow(relay, clientAddr, socksDatagramHeader(target))
+		})
+		if created {
+			Logger.Infof("%s - SOCKS5 udp flow to %s", remoteAddr, target)
+			go h.handleUDPFlow(flow, targetPath(UdpPathPrefix, target), func() {
+				flows.remove(target, flow)
+			})
+		}
+		flow.deliver(packet)
+	}
+	Logger.Infof("%s - SOCKS5 udp association closed", remoteAddr)
+}
+
+// socksHandshake negotiates authentication method and reads the request, returning its command and target.
+func socksHandshake(conn net.Conn, username string, password string) (byte, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, "", err
+	}
+	if header[0] != socksVersion {
+		return 0, "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, "", err
+	}
+	method := byte(socksMethodNoAuth)
+	if username != "" {
+		method = socksMethodUserPass
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		_, _ = conn.Write([]byte{socksVersion, socksMethodNoneFound})
+		return 0, "", errors.New("no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return 0, "", err
+	}
+	if method == socksMethodUserPass {
+		if err := socksAuthenticate(conn, username, password); err != nil {
+			return 0, "", err
+		}
+	}
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return 0, "", err
+	}
+	if request[0] != socksVersion {
+		return 0, "", fmt.Errorf("unsupported socks version %d", request[0])
+	}
+	target, err := readSocksAddr(conn, request[3])
+	if errors.Is(err, errSocksAddressType) {
+		_ = writeSocksReply(conn, socksAddrRejected, nil)
+	}
+	return request[1], target, err
+}
+
+// socksAuthenticate checks username and password sub-negotiation.
+func socksAuthenticate(conn net.Conn, username string, password string) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, header[1:]); err != nil {
+		return err
+	}
+	pass := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return err
+	}
+	if header[0] != socksAuthVersion || string(user) != username || string(pass) != password {
+		_, _ = conn.Write([]byte{socksAuthVersion, 0x01})
+		return errors.New("invalid username or password")
+	}
+	_, err := conn.Write([]byte{socksAuthVersion, 0x00})
+	return err
+}
+
+// readSocksAddr reads address of given type followed by port and returns it as host:port.
+func readSocksAddr(r io.Reader, addrType byte) (string, error) {
+	var host string
+	switch addrType {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if addrType == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errSocksAddressType
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// appendSocksAddr encodes host:port as address type, address and port.
+func appendSocksAddr(b []byte, addr string) []byte {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return append(b, socksAddrIPv4, 0, 0, 0, 0, 0, 0)
+	}
+	port, _ := strconv.Atoi(portString)
+	if ip := net.ParseIP(host); ip == nil {
+		b = append(b, socksAddrDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socksAddrIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksAddrIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// writeSocksReply sends reply with bound address, nil bind reports 0.0.0.0:0.
+func writeSocksReply(conn net.Conn, reply byte, bind net.Addr) error {
+	addr := "0.0.0.0:0"
+	if bind != nil {
+		addr = bind.String()
+	}
+	_, err := conn.Write(appendSocksAddr([]byte{socksVersion, reply, 0}, addr))
+	return err
+}
+
+// parseSocksDatagram splits UDP ASSOCIATE datagram in to destination and payload, fragments are not supported.
+func parseSocksDatagram(datagram []byte) (string, []byte, error) {
+	if len(datagram) < 4 {
+		return "", nil, errors.New("datagram too short")
+	}
+	if datagram[2] != 0 {
+		return "", nil, errors.New("fragmented datagrams are not supported")
+	}
+	r := bytes.NewReader(datagram[4:])
+	target, err := readSocksAddr(r, datagram[3])
+	if err != nil {
+		return "", nil, err
+	}
+	return target, datagram[len(datagram)-r.Len():], nil
+}
+
+// socksDatagramHeader is prepended to datagrams sent back to the client from target.
+func socksDatagramHeader(target string) []byte {
+	return appendSocksAddr([]byte{0, 0, 0}, target)
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// socksDial connects to proxy, authenticates and sends request for target, returning the reply address.
+func socksDial(t *testing.T, proxy string, command byte, target string) (net.Conn, string) {
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	auth := []byte{socksAuthVersion, 4}
+	auth = append(auth, "user"...)
+	auth = append(auth, 4)
+	auth = append(auth, "pass"...)
+	request := appendSocksAddr([]byte{socksVersion, command, 0}, target)
+	_, _ = conn.Write(append(append([]byte{socksVersion, 1, socksMethodUserPass}, auth...), request...))
+	reply := make([]byte, 7)
+	if _, err = io.ReadFull(conn, reply[:4]); err != nil || !bytes.Equal(reply[:4], []byte{socksVersion, socksMethodUserPass, socksAuthVersion, 0}) {
+		t.Fatalf("authentication failed: %v %v", reply[:4], err)
+	}
+	if _, err = io.ReadFull(conn, reply[:4]); err != nil || reply[1] != socksSucceeded {
+		t.Fatalf("request failed: %v %v", reply[:4], err)
+	}
+	bind, err := readSocksAddr(conn, reply[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, bind
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7006")
+	startUdpEchoServer(t, "127.0.0.1:7007")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8086", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1199", "ws://127.0.0.1:8086/tcp/127.0.0.1/1", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithSOCKS5("user", "pass")).Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+
+	conn, _ := socksDial(t, "127.0.0.1:1199", socksCommandConnect, "127.0.0.1:7006")
+	_, _ = conn.Write([]byte("hello"))
+	received := make([]byte, 5)
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != "hello" {
+		t.Errorf("connect echo mismatch: %q %v", received, err)
+	}
+	_ = conn.Close()
+
+	control, bind := socksDial(t, "127.0.0.1:1199", socksCommandAssociate, "0.0.0.0:0")
+	defer control.Close()
+	udpConn, err := net.Dial("udp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = udpConn.SetDeadline(time.Now().Add(time.Second * 5))
+	datagram := append(socksDatagramHeader("127.0.0.1:7007"), "ping"...)
+	if _, err = udpConn.Write(datagram); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, maxDatagramSize)
+	readSize, err := udpConn.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, payload, err := parseSocksDatagram(data[:readSize])
+	if err != nil || target != "127.0.0.1:7007" || string(payload) != "ping" {
+		t.Errorf("associate echo mismatch: %s %q %v", target, payload, err)
+	}
+
+	bad, err := net.Dial("tcp", "127.0.0.1:1199")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = bad.SetDeadline(time.Now().Add(time.Second * 5))
+	_, _ = bad.Write([]byte{socksVersion, 1, socksMethodNoAuth})
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(bad, reply); err != nil || reply[1] != socksMethodNoneFound {
+		t.Errorf("unauthenticated client should be rejected, got %v %v", reply, err)
+	}
+	_ = bad.Close()
+	_, _ = controller.Stop(time.Second)
+}
@@ -0,0 +1,244 @@
+package cli
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/gorilla/websocket"
+	tls "github.com/refraction-networking/utls"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// handshakeBuckets are upper bounds of the handshake duration histogram.
+var handshakeBuckets = [...]time.Duration{
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Millisecond * 2500,
+	time.Second * 5,
+	time.Second * 10,
+}
+
+// dialErrorReasons are the reasons dial errors are grouped by.
+var dialErrorReasons = [...]string{"proxy", "dns", "refused", "timeout", "tls", "handshake", "other"}
+
+// TrafficStats is a snapshot of bytes and messages copied in each direction.
+// Sent is local to remote, received is remote to local. Messages are web socket messages,
+// udp datagrams or reads for Stunnel bridges.
+type TrafficStats struct {
+	BytesSent        int64 `json:"bytesSent"`
+	BytesReceived    int64 `json:"bytesReceived"`
+	MessagesSent     int64 `json:"messagesSent"`
+	MessagesReceived int64 `json:"messagesReceived"`
+}
+
+// add sums other in to s.
+func (s *TrafficStats) add(other TrafficStats) {
+	s.BytesSent += other.BytesSent
+	s.BytesReceived += other.BytesReceived
+	s.MessagesSent += other.MessagesSent
+	s.MessagesReceived += other.MessagesReceived
+}
+
+// ConnectionStats is traffic of one open tunnel.
+type ConnectionStats struct {
+	TrafficStats
+	LocalAddress string    `json:"localAddress"`
+	Since        time.Time `json:"since"`
+}
+
+// ClientStats is a snapshot of client counters, traffic includes closed and open tunnels.
+type ClientStats struct {
+	TrafficStats
+	ActiveConnections int               `json:"activeConnections"`
+	TotalConnections  int64             `json:"totalConnections"`
+	Handshakes        int64             `json:"handshakes"`
+	LastHandshake     time.Duration     `json:"lastHandshakeNs"`
+	AverageHandshake  time.Duration     `json:"averageHandshakeNs"`
+	DialErrors        int64             `json:"dialErrors"`
+	LastError         string            `json:"lastError,omitempty"`
+	Connections       []ConnectionStats `json:"connections"`
+	// HandshakeTotal is the sum of all handshake durations.
+	HandshakeTotal time.Duration `json:"handshakeTotalNs"`
+	// HandshakeHistogram has cumulative handshake counts, slower handshakes are only in Handshakes.
+	HandshakeHistogram []HistogramBucket `json:"handshakeHistogram"`
+	// DialErrorsByReason counts dial errors by dns, refused, timeout, tls, handshake or other.
+	DialErrorsByReason map[string]int64 `json:"dialErrorsByReason"`
+	// Reconnects counts dial retries after a failed attempt and udp flows redialed after their web socket dropped.
+	Reconnects int64 `json:"reconnects"`
+}
+
+// HistogramBucket counts observations less than or equal to UpperBound.
+type HistogramBucket struct {
+	UpperBound time.Duration `json:"upperBoundNs"`
+	Count      int64         `json:"count"`
+}
+
+// trafficCounters
+// counts traffic of a bridge, safe for concurrent use.
+type trafficCounters struct {
+	bytesSent        int64
+	bytesReceived    int64
+	messagesSent     int64
+	messagesReceived int64
+}
+
+func (c *trafficCounters) addSent(bytes int, messages int) {
+	atomic.AddInt64(&c.bytesSent, int64(bytes))
+	atomic.AddInt64(&c.messagesSent, int64(messages))
+}
+
+func (c *trafficCounters) addReceived(bytes int, messages int) {
+	atomic.AddInt64(&c.bytesReceived, int64(bytes))
+	atomic.AddInt64(&c.messagesReceived, int64(messages))
+}
+
+func (c *trafficCounters) snapshot() TrafficStats {
+	return TrafficStats{
+		BytesSent:        atomic.LoadInt64(&c.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&c.bytesReceived),
+		MessagesSent:     atomic.LoadInt64(&c.messagesSent),
+		MessagesReceived: atomic.LoadInt64(&c.messagesReceived),
+	}
+}
+
+// clientCounters
+// counts client wide dial events, traffic is counted by the bridges.
+type clientCounters struct {
+	handshakes     int64
+	handshakeTotal int64
+	lastHandshake  int64
+	dialErrors     int64
+	reconnects     int64
+	// handshakeBuckets has one extra slot for handshakes slower than the last bucket.
+	handshakeBuckets [len(handshakeBuckets) + 1]int64
+	dialErrorReasons [len(dialErrorReasons)]int64
+}
+
+// handshakeDone records duration of a successful dial including tls and web socket handshakes.
+func (c *clientCounters) handshakeDone(duration time.Duration) {
+	atomic.AddInt64(&c.handshakes, 1)
+	atomic.AddInt64(&c.handshakeTotal, int64(duration))
+	atomic.StoreInt64(&c.lastHandshake, int64(duration))
+	i := 0
+	for i < len(handshakeBuckets) && duration > handshakeBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&c.handshakeBuckets[i], 1)
+}
+
+// dialFailed records failed dial attempt and its reason.
+func (c *clientCounters) dialFailed(err error) {
+	atomic.AddInt64(&c.dialErrors, 1)
+	reason := dialErrorReason(err)
+	for i, r := range dialErrorReasons {
+		if r == reason {
+			atomic.AddInt64(&c.dialErrorReasons[i], 1)
+		}
+	}
+}
+
+// dialErrorReason classifies dial error as one of dialErrorReasons.
+func dialErrorReason(err error) string {
+	var dnsErr *net.DNSError
+	var alert tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	var proxyErr *ProxyError
+	switch {
+	case errors.As(err, &proxyErr):
+		return "proxy"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, ErrCertificatePinMismatch), errors.As(err, &alert), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, ErrTargetTimeout):
+		return "timeout"
+	case errors.Is(err, websocket.ErrBadHandshake):
+		return "handshake"
+	}
+	return "other"
+}
+
+// statsRecheck is how often disabled stats logging checks whether reload enabled it.
+const statsRecheck = time.Minute
+
+// WithStatsInterval logs client stats every interval, 0 disables it.
+func WithStatsInterval(interval time.Duration) ClientOption {
+	return func(h *httpClient) {
+		h.statsInterval = interval
+	}
+}
+
+// Stats returns counters of the client and its open tunnels.
+func (h *httpClient) Stats() ClientStats {
+	closed, total, open := h.bridges.stats()
+	stats := ClientStats{
+		TrafficStats:       closed,
+		TotalConnections:   total,
+		Handshakes:         atomic.LoadInt64(&h.counters.handshakes),
+		LastHandshake:      time.Duration(atomic.LoadInt64(&h.counters.lastHandshake)),
+		DialErrors:         atomic.LoadInt64(&h.counters.dialErrors),
+		LastError:          h.controller.Status().LastError,
+		Connections:        []ConnectionStats{},
+		HandshakeTotal:     time.Duration(atomic.LoadInt64(&h.counters.handshakeTotal)),
+		HandshakeHistogram: make([]HistogramBucket, len(handshakeBuckets)),
+		Reconnects:         atomic.LoadInt64(&h.counters.reconnects),
+		DialErrorsByReason: make(map[string]int64, len(dialErrorReasons)),
+	}
+	var cumulative int64
+	for i, upperBound := range handshakeBuckets {
+		cumulative += atomic.LoadInt64(&h.counters.handshakeBuckets[i])
+		stats.HandshakeHistogram[i] = HistogramBucket{UpperBound: upperBound, Count: cumulative}
+	}
+	for i, reason := range dialErrorReasons {
+		stats.DialErrorsByReason[reason] = atomic.LoadInt64(&h.counters.dialErrorReasons[i])
+	}
+	if stats.Handshakes > 0 {
+		stats.AverageHandshake = time.Duration(atomic.LoadInt64(&h.counters.handshakeTotal) / stats.Handshakes)
+	}
+	for _, b := range open {
+		traffic := b.bridge.counters().snapshot()
+		stats.TrafficStats.add(traffic)
+		stats.Connections = append(stats.Connections, ConnectionStats{
+			TrafficStats: traffic,
+			LocalAddress: b.bridge.localAddr().String(),
+			Since:        b.since,
+		})
+	}
+	stats.ActiveConnections = len(stats.Connections)
+	return stats
+}
+
+// logStats writes stats line every statsInterval of the current generation until the client is stopped.
+// While it is disabled the setting is checked again every statsRecheck.
+func (h *httpClient) logStats() {
+	for {
+		interval := h.generation().statsInterval
+		wait := interval
+		if wait <= 0 {
+			wait = statsRecheck
+		}
+		select {
+		case <-h.stopped:
+			return
+		case <-time.After(wait):
+			if interval <= 0 {
+				continue
+			}
+			s := h.Stats()
+			Logger.Infof("Stats - %d active, %d total connections, %d bytes sent, %d bytes received, %d messages sent, %d messages received, handshake %s avg, %d dial errors",
+				s.ActiveConnections, s.TotalConnections, s.BytesSent, s.BytesReceived, s.MessagesSent, s.MessagesReceived, s.AverageHandshake.Round(time.Millisecond), s.DialErrors)
+		}
+	}
+}
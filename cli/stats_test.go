@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	startTcpEchoServer(t, "127.0.0.1:7011")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8091", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	go func() {
+		_ = NewHTTPClient("127.0.0.1:1208", "ws://127.0.0.1:8091/tcp/127.0.0.1/7011", WSTunnel, 1600, func(fd int) {}, controller, false, "").Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	if !echoOnce("127.0.0.1:1208") {
+		t.Fatal("first tunnel failed")
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:1208")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 2))
+	_, _ = conn.Write([]byte("stats"))
+	received := make([]byte, 5)
+	if _, err = io.ReadFull(conn, received); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	stats := controller.Stats()
+	if stats.TotalConnections != 2 || stats.ActiveConnections != 1 || len(stats.Connections) != 1 {
+		t.Errorf("unexpected connection counts %+v", stats)
+	}
+	if stats.BytesSent != 9 || stats.BytesReceived != 9 || stats.MessagesSent != 2 || stats.MessagesReceived != 2 {
+		t.Errorf("unexpected traffic %+v", stats.TrafficStats)
+	}
+	if stats.Handshakes != 2 || stats.AverageHandshake <= 0 || stats.DialErrors != 0 {
+		t.Errorf("unexpected handshake counters %+v", stats)
+	}
+	if last := stats.HandshakeHistogram[len(stats.HandshakeHistogram)-1]; last.Count != 2 || last.UpperBound != time.Second*10 {
+		t.Errorf("unexpected handshake histogram %+v", stats.HandshakeHistogram)
+	}
+	if open := stats.Connections[0]; open.BytesSent != 5 || open.BytesReceived != 5 || open.LocalAddress != conn.LocalAddr().String() {
+		t.Errorf("unexpected connection stats %+v", open)
+	}
+}
+
+func TestStatsWithoutClient(t *testing.T) {
+	if stats := NewController().Stats(); stats.ActiveConnections != 0 || stats.Connections == nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDialErrorReason(t *testing.T) {
+	_, refused := net.Dial("tcp", "127.0.0.1:1")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	_, timeout := (&net.Dialer{}).DialContext(ctx, "tcp", "10.255.255.1:443")
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{refused, "refused"},
+		{timeout, "timeout"},
+		{ErrTargetTimeout, "timeout"},
+		{&net.DNSError{Err: "no such host", Name: "invalid.", IsNotFound: true}, "dns"},
+		{fmt.Errorf("%w: example.com presented abc", ErrCertificatePinMismatch), "tls"},
+		{websocket.ErrBadHandshake, "handshake"},
+		{&ProxyError{Proxy: "http://127.0.0.1:3128", Err: refused}, "proxy"},
+		{errors.New("unexpected"), "other"},
+	}
+	for _, test := range tests {
+		if reason := dialErrorReason(test.err); reason != test.reason {
+			t.Errorf("dialErrorReason(%v) = %s, expected %s", test.err, reason, test.reason)
+		}
+	}
+}
+
+func TestHandshakeHistogram(t *testing.T) {
+	var counters clientCounters
+	counters.handshakeDone(time.Millisecond * 10)
+	counters.handshakeDone(time.Millisecond * 300)
+	counters.handshakeDone(time.Second * 30)
+	if counters.handshakeBuckets[0] != 1 || counters.handshakeBuckets[3] != 1 || counters.handshakeBuckets[len(handshakeBuckets)] != 1 {
+		t.Errorf("unexpected buckets %v", counters.handshakeBuckets)
+	}
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// stunnelServer
+// terminates TLS connections and forwards plain traffic to the upstream tcp address.
+// //////////////////////////////////////////////////////////////////////////////
+type stunnelServer struct {
+	listenAddress   string
+	upstreamAddress string
+	certFile        string
+	keyFile         string
+	mtu             int
+}
+
+func NewStunnelServer(listenAddress string, upstreamAddress string, certFile string, keyFile string, mtu int) Runner {
+	return &stunnelServer{
+		listenAddress:   listenAddress,
+		upstreamAddress: upstreamAddress,
+		certFile:        certFile,
+		keyFile:         keyFile,
+		mtu:             mtu,
+	}
+}
+
+// Run starts tls listener and forwards every accepted connection to upstream.
+func (s *stunnelServer) Run() error {
+	if s.upstreamAddress == "" {
+		return errors.New("stunnel server requires upstream address")
+	}
+	cert, err := LoadServerCertificate(s.certFile, s.keyFile)
+	if err != nil {
+		Logger.Errorf("Error loading certificate: %s", err)
+		return err
+	}
+	listener, err := tls.Listen("tcp", s.listenAddress, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	Logger.Infof("Listening for stunnel connections on %s > %s", s.listenAddress, s.upstreamAddress)
+	return s.serve(listener)
+}
+
+// serve forwards connections accepted by listener until it is closed. Accept errors like running out of file
+// descriptors back off from 5ms doubling up to 1s, as net/http does, instead of spinning.
+func (s *stunnelServer) serve(listener net.Listener) error {
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = time.Millisecond * 5
+			} else {
+				delay *= 2
+			}
+			if delay > time.Second {
+				delay = time.Second
+			}
+			Logger.Errorf("Error accepting stunnel connection: %s, retrying in %s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go s.handleConnection(conn.(*tls.Conn))
+	}
+}
+
+// handleConnection completes the tls handshake and bridges it to upstream.
+func (s *stunnelServer) handleConnection(tlsConn *tls.Conn) {
+	_ = tlsConn.SetDeadline(time.Now().Add(time.Second * 10))
+	if err := tlsConn.Handshake(); err != nil {
+		Logger.Errorf("%s - Error on handshake: %s", tlsConn.RemoteAddr(), err)
+		_ = tlsConn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	upstreamConn, err := net.DialTimeout("tcp", s.upstreamAddress, time.Second*10)
+	if err != nil {
+		Logger.Errorf("%s - Error while dialing upstream %s: %s", tlsConn.RemoteAddr(), s.upstreamAddress, err)
+		_ = tlsConn.Close()
+		return
+	}
+	Logger.Infof("%s - Stunnel opened to %s", tlsConn.RemoteAddr(), s.upstreamAddress)
+	b := NewStunnelBiDirection(upstreamConn, tlsConn, s.mtu)
+	_ = b.Run()
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingListener fails every Accept with EMFILE until it is closed.
+type failingListener struct {
+	net.Listener
+	accepts []time.Time
+	closed  bool
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	l.accepts = append(l.accepts, time.Now())
+	if len(l.accepts) == 6 {
+		l.closed = true
+	}
+	return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+}
+
+func TestStunnelServerBacksOffOnAcceptError(t *testing.T) {
+	listener := &failingListener{}
+	server := &stunnelServer{}
+	if err := server.serve(listener); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("serve should return when listener is closed, got %v", err)
+	}
+	// Delays are 5, 10, 20, 40 and 80ms between the six accepts.
+	elapsed := listener.accepts[len(listener.accepts)-1].Sub(listener.accepts[0])
+	if elapsed < time.Millisecond*150 {
+		t.Errorf("accept errors should back off, 6 accepts took %s", elapsed)
+	}
+}
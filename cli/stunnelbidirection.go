@@ -1,22 +1,28 @@
 package cli
 
 import (
-	tls "github.com/refraction-networking/utls"
 	"net"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 // StunnelBiDirection
-// creates an object to transfer data between the TCP clients and remote server in bidirectional way
+// creates an object to transfer data between the TCP clients and remote server in bidirectional way.
+// remoteConn is the TLS side, a client *tls.UConn or a connection accepted by the stunnel server.
 type StunnelBiDirection struct {
 	localConn  net.Conn
-	remoteConn *tls.UConn
+	remoteConn net.Conn
 	mtu        int
+	draining   int32
+	traffic    trafficCounters
 }
 
-func NewStunnelBiDirection(localConn net.Conn, remoteConn *tls.UConn, mtu int) Runner {
+func NewStunnelBiDirection(localConn net.Conn, remoteConn net.Conn, mtu int) Runner {
 	return &StunnelBiDirection{
-		localConn, remoteConn, mtu,
+		localConn:  localConn,
+		remoteConn: remoteConn,
+		mtu:        mtu,
 	}
 }
 
@@ -26,17 +32,28 @@ func (s *StunnelBiDirection) Run() error {
 	return nil
 }
 
-// sendTCPToStunnel copies tcp traffic to remote server
+// sendTCPToStunnel copies tcp traffic to remote server.
+// When draining it closes the write side of remote connection and leaves the rest open until remote closes.
 func (s *StunnelBiDirection) sendTCPToStunnel() {
-	defer s.close()
 	data := make([]byte, s.mtu)
 	for {
 		readSize, err := s.localConn.Read(data)
-		if err != nil && !os.IsTimeout(err) {
+		if err != nil && !os.IsTimeout(err) && !s.isDraining() {
+			s.close()
 			return
 		}
-		_, _ = s.remoteConn.Write(data[:readSize])
+		written, _ := s.remoteConn.Write(data[:readSize])
+		if written > 0 {
+			s.traffic.addSent(written, 1)
+		}
 		if err != nil {
+			if s.isDraining() {
+				if remote, ok := s.remoteConn.(interface{ CloseWrite() error }); ok {
+					_ = remote.CloseWrite()
+				}
+				return
+			}
+			s.close()
 			return
 		}
 	}
@@ -51,13 +68,34 @@ func (s *StunnelBiDirection) sendStunnelToTCP() {
 		if err != nil && !os.IsTimeout(err) {
 			break
 		}
-		_, _ = s.localConn.Write(data[:readSize])
+		written, _ := s.localConn.Write(data[:readSize])
+		if written > 0 {
+			s.traffic.addReceived(written, 1)
+		}
 		if err != nil {
 			return
 		}
 	}
 }
 
+// shutdown stops reading local connection and half closes remote connection when it supports it.
+func (s *StunnelBiDirection) shutdown() {
+	atomic.StoreInt32(&s.draining, 1)
+	_ = s.localConn.SetReadDeadline(time.Now())
+}
+
+func (s *StunnelBiDirection) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *StunnelBiDirection) counters() *trafficCounters {
+	return &s.traffic
+}
+
+func (s *StunnelBiDirection) localAddr() net.Addr {
+	return s.localConn.RemoteAddr()
+}
+
 // close closes connections.
 func (s *StunnelBiDirection) close() {
 	_ = s.remoteConn.Close()
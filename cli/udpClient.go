@@ -0,0 +1,354 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUDPIdleTimeout is how long a udp flow is kept without traffic when no idle timeout is configured.
+const DefaultUDPIdleTimeout = time.Second * 60
+
+// udpFlowBacklog is the number of datagrams queued per flow while its web socket is dialed.
+const udpFlowBacklog = 64
+
+// WithUDPIdleTimeout closes udp flows and their web sockets when no datagram passed in either direction for timeout.
+func WithUDPIdleTimeout(timeout time.Duration) ClientOption {
+	return func(h *httpClient) {
+		h.udpIdle = timeout
+	}
+}
+
+// prepareUDP replaces settings udp tunnels do not support.
+func (h *httpClient) prepareUDP() {
+	if h.multiplex {
+		Logger.Warnf("Multiplexing is not supported for udp tunnels, using one web socket per flow.")
+		h.multiplex = false
+	}
+	if h.udpIdle <= 0 {
+		h.udpIdle = DefaultUDPIdleTimeout
+	}
+}
+
+// udpListener
+// is a local udp socket and the number of flows answering from it. Reload replaces the current listener,
+// a retired listener keeps serving its flows and is closed after the last one.
+type udpListener struct {
+	conn    *net.UDPConn
+	flows   atomic.Int64
+	retired atomic.Bool
+}
+
+// bindUDP listens on udp address.
+func bindUDP(address string) (*udpListener, error) {
+	udpAdr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		Logger.Errorf("Error resolving udp address: %s", err)
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAdr)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof("Listening for udp on %s", address)
+	return &udpListener{conn: conn}, nil
+}
+
+// release is called when a flow of the listener closed.
+func (l *udpListener) release() {
+	if l.flows.Add(-1) == 0 && l.retired.Load() {
+		_ = l.conn.Close()
+	}
+}
+
+// retire stops new flows on the listener and closes it once it has none.
+func (l *udpListener) retire() {
+	l.retired.Store(true)
+	if l.flows.Load() == 0 {
+		_ = l.conn.Close()
+	}
+}
+
+// runUDP listens on local udp socket and carries every client address as separate flow over its own web socket.
+// Flows use the settings generation current when their first datagram arrived.
+func (h *httpClient) runUDP() error {
+	listener, err := bindUDP(h.listenTCP)
+	if err != nil {
+		return err
+	}
+	h.genMu.Lock()
+	h.udpListener = listener
+	h.genMu.Unlock()
+	go h.readUDP(listener)
+	<-h.stopped
+	h.udpFlows.closeAll()
+	return nil
+}
+
+// readUDP delivers datagrams of listener to their flows until listener is closed or client stops.
+// New flows are created only while listener is current.
+func (r *clientRuntime) readUDP(listener *udpListener) {
+	go func() {
+		<-r.stopped
+		_ = listener.conn.Close()
+	}()
+	data := make([]byte, maxDatagramSize)
+	for {
+		readSize, clientAddr, err := listener.conn.ReadFromUDP(data)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		packet := make([]byte, readSize)
+		copy(packet, data[:readSize])
+		remoteAddr := clientAddr.String()
+		// Client address may reach the old and the new listener after reload, each has its own flow.
+		key := remoteAddr + " > " + listener.conn.LocalAddr().String()
+		flow, created := r.udpFlows.get(key, func() *udpFlow {
+			if r.controller.paused() || listener.retired.Load() {
+				return nil
+			}
+			listener.flows.Add(1)
+			return newUDPFlow(listener.conn, clientAddr, nil)
+		})
+		if flow == nil {
+			continue
+		}
+		if created {
+			Logger.Infof("New udp flow from %s", remoteAddr)
+			r.controller.connectionOpened(remoteAddr)
+			go r.generation().handleUDPFlow(flow, "", func() {
+				r.udpFlows.remove(key, flow)
+				r.controller.connectionClosed(remoteAddr)
+				listener.release()
+			})
+		}
+		flow.deliver(packet)
+	}
+}
+
+// handleUDPFlow dials remotes for the flow and relays its datagrams until it expires, then calls onClose.
+// When the web socket drops mid-session the flow is kept and redialed under the reconnect policy, datagrams
+// sent meanwhile are lost like on any udp path. A web socket dropping before it carried a datagram back counts
+// as failed attempt so a remote closing every tunnel right away is not redialed forever.
+// path replaces the web socket url path when set.
+func (h *httpClient) handleUDPFlow(flow *udpFlow, path string, onClose func()) {
+	defer onClose()
+	remoteAddr := flow.clientAddr.String()
+	redials := 0
+	for {
+		var leg *tunnelLeg
+		err := h.dialWithRetry(remoteAddr, func() error {
+			var err error
+			leg, err = h.dialRemotes(remoteAddr, path)
+			return err
+		})
+		if err != nil {
+			Logger.Errorf("%s - Remote server connection > Error while dialing %s: %s", remoteAddr, h.remoteServer, err)
+			_ = flow.Close()
+			return
+		}
+		_ = flow.SetReadDeadline(time.Time{})
+		bridge := newUDPBiDirection(flow, leg.wsConn, h.udpIdle)
+		bridge.keepUDP = h.reconnect.MaxAttempts > 1
+		h.runBridge(bridge)
+		if !bridge.dropped || flow.closed() {
+			break
+		}
+		if atomic.LoadInt64(&bridge.traffic.messagesReceived) > 0 {
+			redials = 0
+		}
+		redials++
+		if redials >= h.reconnect.MaxAttempts {
+			Logger.Errorf("%s - Web socket of udp flow dropped %d times without traffic, giving up.", remoteAddr, redials)
+			_ = flow.Close()
+			break
+		}
+		delay := h.reconnect.delay(redials)
+		Logger.Warnf("%s - Web socket of udp flow dropped, redialing in %s.", remoteAddr, delay)
+		select {
+		case <-time.After(delay):
+		case <-h.stopped:
+			_ = flow.Close()
+			return
+		}
+		atomic.AddInt64(&h.counters.reconnects, 1)
+	}
+	Logger.Infof("Udp flow from %s closed", remoteAddr)
+}
+
+// udpFlowTable
+// keeps open flows by key, flows are removed by their handler once closed.
+type udpFlowTable struct {
+	mu    sync.Mutex
+	flows map[string]*udpFlow
+}
+
+func newUDPFlowTable() *udpFlowTable {
+	return &udpFlowTable{flows: make(map[string]*udpFlow)}
+}
+
+// get returns open flow for key, creating a new one when there is none.
+// create may return nil to refuse the flow.
+func (t *udpFlowTable) get(key string, create func() *udpFlow) (*udpFlow, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if flow, ok := t.flows[key]; ok && !flow.closed() {
+		return flow, false
+	}
+	flow := create()
+	if flow == nil {
+		return nil, false
+	}
+	t.flows[key] = flow
+	return flow, true
+}
+
+// remove forgets the flow unless a newer one already replaced it.
+func (t *udpFlowTable) remove(key string, flow *udpFlow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.flows[key] == flow {
+		delete(t.flows, key)
+	}
+}
+
+// len returns number of tracked flows.
+func (t *udpFlowTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
+
+// closeAll closes all flows when the listener stops.
+func (t *udpFlowTable) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, flow := range t.flows {
+		_ = flow.Close()
+	}
+}
+
+// udpFlow
+// is the net.Conn side of one local udp client, reads return queued datagrams and writes are sent back
+// to the client from the shared listener, prefixed with header when set.
+type udpFlow struct {
+	listener   *net.UDPConn
+	clientAddr *net.UDPAddr
+	header     []byte
+	queue      chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+	// deadline is closed once the read deadline passed, replaced by SetReadDeadline.
+	deadlineMu    sync.Mutex
+	deadline      chan struct{}
+	deadlineTimer *time.Timer
+}
+
+func newUDPFlow(listener *net.UDPConn, clientAddr *net.UDPAddr, header []byte) *udpFlow {
+	return &udpFlow{
+		listener:   listener,
+		clientAddr: clientAddr,
+		header:     header,
+		queue:      make(chan []byte, udpFlowBacklog),
+		done:       make(chan struct{}),
+		deadline:   make(chan struct{}),
+	}
+}
+
+// deliver queues datagram for the web socket, it is dropped when the queue is full like udp would.
+func (f *udpFlow) deliver(packet []byte) {
+	select {
+	case f.queue <- packet:
+	default:
+	}
+}
+
+// closed reports whether the flow has been closed.
+func (f *udpFlow) closed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *udpFlow) Read(b []byte) (int, error) {
+	f.deadlineMu.Lock()
+	deadline := f.deadline
+	f.deadlineMu.Unlock()
+	select {
+	case packet := <-f.queue:
+		return copy(b, packet), nil
+	case <-f.done:
+		return 0, io.EOF
+	case <-deadline:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (f *udpFlow) Write(b []byte) (int, error) {
+	if f.closed() {
+		return 0, net.ErrClosed
+	}
+	if f.header == nil {
+		return f.listener.WriteToUDP(b, f.clientAddr)
+	}
+	if _, err := f.listener.WriteToUDP(append(f.header[:len(f.header):len(f.header)], b...), f.clientAddr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (f *udpFlow) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+	return nil
+}
+
+func (f *udpFlow) LocalAddr() net.Addr {
+	return f.listener.LocalAddr()
+}
+
+func (f *udpFlow) RemoteAddr() net.Addr {
+	return f.clientAddr
+}
+
+func (f *udpFlow) SetDeadline(t time.Time) error {
+	return f.SetReadDeadline(t)
+}
+
+// SetReadDeadline interrupts pending and future reads once t passed, zero t removes the deadline.
+func (f *udpFlow) SetReadDeadline(t time.Time) error {
+	f.deadlineMu.Lock()
+	defer f.deadlineMu.Unlock()
+	if f.deadlineTimer != nil {
+		f.deadlineTimer.Stop()
+		f.deadlineTimer = nil
+	}
+	deadline := make(chan struct{})
+	f.deadline = deadline
+	if t.IsZero() {
+		return nil
+	}
+	if wait := time.Until(t); wait > 0 {
+		f.deadlineTimer = time.AfterFunc(wait, func() {
+			close(deadline)
+		})
+	} else {
+		close(deadline)
+	}
+	return nil
+}
+
+func (f *udpFlow) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startUdpEchoServer echoes every datagram back to its sender.
+func startUdpEchoServer(t *testing.T, addr string) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		data := make([]byte, maxDatagramSize)
+		for {
+			readSize, from, err := conn.ReadFrom(data)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(data[:readSize], from)
+		}
+	}()
+}
+
+func TestUDPTunnel(t *testing.T) {
+	startUdpEchoServer(t, "127.0.0.1:7005")
+	go func() {
+		_ = NewWsTunnelServer("127.0.0.1:8085", "", "", 1600).Run()
+	}()
+	controller := NewController()
+	client := NewHTTPClient("127.0.0.1:1198", "ws://127.0.0.1:8085/udp/127.0.0.1/7005", WSTunnel, 1600, func(fd int) {}, controller, false, "", WithUDPIdleTimeout(time.Millisecond*400)).(*httpClient)
+	go func() {
+		_ = client.Run()
+	}()
+	time.Sleep(time.Millisecond * 200)
+	for _, size := range []int{1, 1400, 9000} {
+		conn, err := net.Dial("udp", "127.0.0.1:1198")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+		// First datagram is queued while the flow dials its web socket.
+		for i := 0; i < 3; i++ {
+			if _, err = conn.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			received := make([]byte, maxDatagramSize)
+			readSize, err := conn.Read(received)
+			if err != nil || !bytes.Equal(received[:readSize], payload) {
+				t.Fatalf("echo mismatch for %d byte datagram: %d %v", size, readSize, err)
+			}
+		}
+		_ = conn.Close()
+	}
+	if flows := client.udpFlows.len(); flows != 3 {
+		t.Errorf("expected a flow per client address, got %d", flows)
+	}
+	time.Sleep(time.Second)
+	if flows := client.udpFlows.len(); flows != 0 {
+		t.Errorf("idle flows should expire, %d left", flows)
+	}
+	_, _ = controller.Stop(time.Second)
+}
+
+func TestUDPFlowRedial(t *testing.T) {
+	var upgrades int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer wsConn.Close()
+		// First web socket drops after one echo, later ones keep echoing.
+		first := atomic.AddInt32(&upgrades, 1) == 1
+		for {
+			messageType, data, err := wsConn.ReadMessage()
+			if err != nil || wsConn.WriteMessage(messageType, data) != nil || first {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	controller := NewController()
+	client := NewHTTPClient("127.0.0.1:1219", "ws"+strings.TrimPrefix(server.URL, "http")+"/udp/127.0.0.1/7016", WSTunnel, 1600, func(fd int) {}, controller, false, "",
+		WithReconnectPolicy(ReconnectPolicy{InitialDelay: time.Millisecond * 10, MaxAttempts: 3}, nil)).(*httpClient)
+	go func() {
+		_ = client.Run()
+	}()
+	defer func() { _, _ = controller.Stop(time.Second) }()
+	time.Sleep(time.Millisecond * 200)
+	conn, err := net.Dial("udp", "127.0.0.1:1219")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	echoed := 0
+	received := make([]byte, maxDatagramSize)
+	for i := 0; i < 20 && echoed < 2; i++ {
+		_, _ = conn.Write([]byte("ping"))
+		_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		if readSize, err := conn.Read(received); err == nil && string(received[:readSize]) == "ping" {
+			echoed++
+		}
+	}
+	if echoed < 2 {
+		t.Fatalf("flow should carry datagrams after its web socket dropped, got %d echoes", echoed)
+	}
+	if n := atomic.LoadInt32(&upgrades); n != 2 {
+		t.Errorf("dropped web socket should be redialed once, got %d upgrades", n)
+	}
+	if flows := client.udpFlows.len(); flows != 1 {
+		t.Errorf("redial should keep the flow, got %d flows", flows)
+	}
+}
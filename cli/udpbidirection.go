@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// maxDatagramSize is the largest udp payload, web socket messages above it are rejected.
+const maxDatagramSize = 65535
+
+// errWebSocketDropped is returned by Run of relays keeping udp open when the web socket failed first.
+var errWebSocketDropped = errors.New("web socket dropped")
+
+// UDPBiDirection
+// relays datagrams between udp connection and web socket, each datagram is carried in its own binary message
+// so boundaries and lengths are preserved.
+type UDPBiDirection struct {
+	udpConn     net.Conn
+	wsConn      *websocket.Conn
+	idleTimeout time.Duration
+	lastActive  int64
+	done        chan struct{}
+	closeOnce   sync.Once
+	traffic     trafficCounters
+	// keepUDP leaves udp connection open when web socket fails so a new web socket can take over the flow.
+	keepUDP bool
+	dropped bool
+}
+
+// NewUDPBiDirection creates relay which is closed once no datagram passed in either direction for idleTimeout,
+// idleTimeout of 0 keeps it open until one of the connections fails.
+func NewUDPBiDirection(udpConn net.Conn, wsConn *websocket.Conn, idleTimeout time.Duration) Runner {
+	return newUDPBiDirection(udpConn, wsConn, idleTimeout)
+}
+
+func newUDPBiDirection(udpConn net.Conn, wsConn *websocket.Conn, idleTimeout time.Duration) *UDPBiDirection {
+	return &UDPBiDirection{
+		udpConn:     udpConn,
+		wsConn:      wsConn,
+		idleTimeout: idleTimeout,
+		lastActive:  time.Now().UnixNano(),
+		done:        make(chan struct{}),
+	}
+}
+
+// sendUDPToWS copies datagrams to web socket connection.
+func (b *UDPBiDirection) sendUDPToWS() {
+	defer b.close()
+	data := make([]byte, maxDatagramSize)
+	for {
+		readSize, err := b.udpConn.Read(data)
+		if err != nil {
+			// Icmp port unreachable from the target is reported on the next read, the target may come back.
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue
+			}
+			return
+		}
+		b.touch()
+		if err := b.wsConn.WriteMessage(websocket.BinaryMessage, data[:readSize]); err != nil {
+			b.drop()
+			return
+		}
+		b.traffic.addSent(readSize, 1)
+	}
+}
+
+// sendWSToUDP copies web socket messages to udp connection, one datagram each.
+func (b *UDPBiDirection) sendWSToUDP() {
+	defer b.close()
+	b.wsConn.SetReadLimit(maxDatagramSize)
+	for {
+		messageType, data, err := b.wsConn.ReadMessage()
+		if err != nil {
+			b.drop()
+			return
+		}
+		if messageType != websocket.BinaryMessage {
+			Logger.Infof("WSToUDP - Got wrong message type from WS: %d", messageType)
+			return
+		}
+		b.touch()
+		if _, err := b.udpConn.Write(data); err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+			return
+		}
+		b.traffic.addReceived(len(data), 1)
+	}
+}
+
+// touch records traffic for idle expiry.
+func (b *UDPBiDirection) touch() {
+	atomic.StoreInt64(&b.lastActive, time.Now().UnixNano())
+}
+
+// expire closes the relay once it has been idle for idleTimeout.
+func (b *UDPBiDirection) expire() {
+	ticker := time.NewTicker(b.idleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&b.lastActive)))
+			if idle >= b.idleTimeout {
+				Logger.Infof("Udp flow %s idle for %s, closing.", b.udpConn.RemoteAddr(), idle.Round(time.Second))
+				b.close()
+				return
+			}
+		}
+	}
+}
+
+func (b *UDPBiDirection) Run() error {
+	if b.idleTimeout > 0 {
+		go b.expire()
+	}
+	go b.sendUDPToWS()
+	b.sendWSToUDP()
+	if b.dropped {
+		return errWebSocketDropped
+	}
+	return nil
+}
+
+// shutdown closes the relay right away, datagrams have nothing to drain.
+func (b *UDPBiDirection) shutdown() {
+	b.close()
+}
+
+func (b *UDPBiDirection) counters() *trafficCounters {
+	return &b.traffic
+}
+
+func (b *UDPBiDirection) localAddr() net.Addr {
+	return b.udpConn.RemoteAddr()
+}
+
+// drop closes only the web socket when udp is kept, the udp read is interrupted by its deadline.
+func (b *UDPBiDirection) drop() {
+	if !b.keepUDP {
+		b.close()
+		return
+	}
+	b.closeOnce.Do(func() {
+		b.dropped = true
+		close(b.done)
+		_ = b.wsConn.Close()
+		_ = b.udpConn.SetReadDeadline(time.Now())
+	})
+}
+
+// close closes connections.
+func (b *UDPBiDirection) close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		_ = b.wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		_ = b.wsConn.Close()
+		_ = b.udpConn.Close()
+	})
+}
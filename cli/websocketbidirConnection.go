@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"errors"
 	"github.com/gorilla/websocket"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,31 +17,52 @@ type WebSocketBiDirection struct {
 	wsConn         *websocket.Conn
 	tcpReadTimeout time.Duration
 	mtu            int
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	done           chan struct{}
+	closeOnce      sync.Once
+	draining       int32
+	traffic        trafficCounters
 }
 
-func NewBidirConnection(tcpConn net.Conn, wsConn *websocket.Conn, tcpReadTimeout time.Duration, mtu int) Runner {
+// NewBidirConnection creates bridge, pingInterval of 0 disables keepalive pings.
+// When pings are enabled and no pong or data arrives within pingInterval + pongTimeout the peer is considered dead.
+func NewBidirConnection(tcpConn net.Conn, wsConn *websocket.Conn, tcpReadTimeout time.Duration, mtu int, pingInterval time.Duration, pongTimeout time.Duration) Runner {
 	return &WebSocketBiDirection{
 		tcpConn:        tcpConn,
 		wsConn:         wsConn,
 		tcpReadTimeout: tcpReadTimeout,
 		mtu:            mtu,
+		pingInterval:   pingInterval,
+		pongTimeout:    pongTimeout,
+		done:           make(chan struct{}),
 	}
 }
 
 // sendTCPToWS copies tcp traffic to web socket connection.
+// When draining it sends close frame and leaves the bridge open until peer answers it.
 func (b *WebSocketBiDirection) sendTCPToWS() {
-	defer b.close()
 	data := make([]byte, b.mtu)
 	for {
 		if b.tcpReadTimeout > 0 {
 			_ = b.tcpConn.SetReadDeadline(time.Now().Add(b.tcpReadTimeout))
 		}
+		if b.isDraining() {
+			_ = b.tcpConn.SetReadDeadline(time.Now())
+		}
 		readSize, err := b.tcpConn.Read(data)
 		if err != nil && !os.IsTimeout(err) {
+			b.close()
 			return
 		}
 
 		if err := b.wsConn.WriteMessage(websocket.BinaryMessage, data[:readSize]); err != nil {
+			b.close()
+			return
+		}
+		b.traffic.addSent(readSize, 1)
+		if b.isDraining() {
+			_ = b.wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
 			return
 		}
 	}
@@ -49,14 +73,20 @@ func (b *WebSocketBiDirection) sendWSToTCP() {
 	defer b.close()
 	data := make([]byte, b.mtu)
 	for {
+		b.extendReadDeadline()
 		messageType, wsReader, err := b.wsConn.NextReader()
 		if err != nil {
+			var netErr net.Error
+			if b.pingInterval > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				Logger.Warnf("WSToTCP - Peer %s stopped answering pings for %s, closing tunnel.", b.wsConn.RemoteAddr(), b.pingInterval+b.pongTimeout)
+			}
 			return
 		}
 		if messageType != websocket.BinaryMessage {
 			Logger.Infof("WSToTCP - Got wrong message type from WS: %s", messageType)
 			return
 		}
+		b.traffic.addReceived(0, 1)
 
 		for {
 			readSize, err := wsReader.Read(data)
@@ -67,19 +97,73 @@ func (b *WebSocketBiDirection) sendWSToTCP() {
 			if _, err := b.tcpConn.Write(data[:readSize]); err != nil {
 				return
 			}
+			b.traffic.addReceived(readSize, 0)
+		}
+	}
+}
+
+// keepalive sends pings until the bridge is closed.
+func (b *WebSocketBiDirection) keepalive() {
+	ticker := time.NewTicker(b.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := b.wsConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(b.pongTimeout)); err != nil {
+				Logger.Warnf("Keepalive - Failed to send ping to %s: %s", b.wsConn.RemoteAddr(), err)
+				b.close()
+				return
+			}
 		}
 	}
 }
 
+// extendReadDeadline gives peer another ping interval plus pong timeout to show it is alive.
+func (b *WebSocketBiDirection) extendReadDeadline() {
+	if b.pingInterval > 0 {
+		_ = b.wsConn.SetReadDeadline(time.Now().Add(b.pingInterval + b.pongTimeout))
+	}
+}
+
 func (b *WebSocketBiDirection) Run() error {
+	if b.pingInterval > 0 {
+		b.wsConn.SetPongHandler(func(string) error {
+			b.extendReadDeadline()
+			return nil
+		})
+		go b.keepalive()
+	}
 	go b.sendTCPToWS()
 	b.sendWSToTCP()
 	return nil
 }
 
+// shutdown stops reading tcp connection and closes web socket with close handshake.
+func (b *WebSocketBiDirection) shutdown() {
+	atomic.StoreInt32(&b.draining, 1)
+	_ = b.tcpConn.SetReadDeadline(time.Now())
+}
+
+func (b *WebSocketBiDirection) isDraining() bool {
+	return atomic.LoadInt32(&b.draining) == 1
+}
+
+func (b *WebSocketBiDirection) counters() *trafficCounters {
+	return &b.traffic
+}
+
+func (b *WebSocketBiDirection) localAddr() net.Addr {
+	return b.tcpConn.RemoteAddr()
+}
+
 // close closes connections.
 func (b *WebSocketBiDirection) close() {
-	_ = b.wsConn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second))
-	_ = b.wsConn.Close()
-	_ = b.tcpConn.Close()
+	b.closeOnce.Do(func() {
+		close(b.done)
+		_ = b.wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		_ = b.wsConn.Close()
+		_ = b.tcpConn.Close()
+	})
 }
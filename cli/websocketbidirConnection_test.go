@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveDetectsDeadPeer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	// Server never reads, so pings are never answered with pongs.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-release
+		_ = conn.Close()
+	}))
+	defer server.Close()
+	wsConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	finished := make(chan struct{})
+	go func() {
+		_ = NewBidirConnection(local, wsConn, time.Second*10, 1500, time.Millisecond*50, time.Millisecond*50).Run()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 2):
+		t.Fatal("bridge should be closed when peer stops answering pings")
+	}
+}
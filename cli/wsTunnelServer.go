@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TcpPathPrefix is the request path prefix used by clients to select a tcp target.
+const TcpPathPrefix = "/tcp/"
+
+// UdpPathPrefix is the request path prefix used by clients to select a udp target.
+const UdpPathPrefix = "/udp/"
+
+// wsTunnelServer
+// accepts web socket upgrades and bridges them to the tcp or udp target from the request path.
+// //////////////////////////////////////////////////////////////////////////////
+type wsTunnelServer struct {
+	listenAddress string
+	certFile      string
+	keyFile       string
+	mtu           int
+	upgrader      websocket.Upgrader
+	allowReverse  bool
+	reverseMu     sync.Mutex
+	pending       map[string]net.Conn
+	auth          *TokenAuth
+	dialTimeout   time.Duration
+}
+
+// ServerOption configures optional wsTunnelServer features.
+type ServerOption func(s *wsTunnelServer)
+
+// ErrTargetTimeout is returned by clients when the server did not reach the tunnel target in time.
+// The server answers such upgrade requests with 504 and rejects such mux streams with a timeout reason,
+// it unwraps to websocket.ErrBadHandshake.
+var ErrTargetTimeout = fmt.Errorf("tunnel target did not answer in time: %w", websocket.ErrBadHandshake)
+
+// WithTargetDialTimeout replaces the default 10 seconds the server waits for tcp tunnel targets.
+func WithTargetDialTimeout(timeout time.Duration) ServerOption {
+	return func(s *wsTunnelServer) {
+		s.dialTimeout = timeout
+	}
+}
+
+func NewWsTunnelServer(listenAddress string, certFile string, keyFile string, mtu int, options ...ServerOption) Runner {
+	s := &wsTunnelServer{
+		listenAddress: listenAddress,
+		certFile:      certFile,
+		keyFile:       keyFile,
+		mtu:           mtu,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  mtu,
+			WriteBufferSize: mtu,
+			Subprotocols:    []string{MuxSubprotocol},
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		pending:     make(map[string]net.Conn),
+		dialTimeout: time.Second * 10,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Run starts http server and serves tunnel requests until it fails.
+func (s *wsTunnelServer) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(TcpPathPrefix, s.handleTcpTunnel)
+	mux.HandleFunc(UdpPathPrefix, s.handleUdpTunnel)
+	mux.HandleFunc(ReversePathPrefix, s.handleReverseControl)
+	mux.HandleFunc(ReverseDataPathPrefix, s.handleReverseData)
+	server := &http.Server{
+		Addr:              s.listenAddress,
+		Handler:           s.authenticate(mux),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	var err error
+	if s.certFile != "" && s.keyFile != "" {
+		Logger.Infof("Listening for wss tunnels on %s", s.listenAddress)
+		err = server.ListenAndServeTLS(s.certFile, s.keyFile)
+	} else {
+		Logger.Infof("Listening for ws tunnels on %s", s.listenAddress)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		Logger.Errorf("Tunnel server stopped: %s", err)
+	}
+	return err
+}
+
+// handleTcpTunnel dials the tcp target and bridges it to the upgraded web socket connection.
+func (s *wsTunnelServer) handleTcpTunnel(w http.ResponseWriter, r *http.Request) {
+	target, err := parseTargetPath(r.URL.Path, TcpPathPrefix)
+	if err != nil {
+		Logger.Errorf("%s - Invalid tunnel path %s: %s", r.RemoteAddr, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wantsMux(r) {
+		s.serveMux(w, r, target)
+		return
+	}
+	tcpConn, err := net.DialTimeout("tcp", target, s.dialTimeout)
+	if err != nil {
+		Logger.Errorf("%s - Error while dialing target %s: %s", r.RemoteAddr, target, err)
+		http.Error(w, "Unable to reach tunnel target.", targetDialStatus(err))
+		return
+	}
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Errorf("%s - Upgrade failed: %s", r.RemoteAddr, err)
+		_ = tcpConn.Close()
+		return
+	}
+	Logger.Infof("%s - Tunnel opened to %s", r.RemoteAddr, target)
+	b := NewBidirConnection(tcpConn, wsConn, time.Second*10, s.mtu, 0, 0)
+	_ = b.Run()
+	Logger.Infof("%s - Tunnel closed to %s", r.RemoteAddr, target)
+}
+
+// handleUdpTunnel dials the udp target and relays datagrams over the upgraded web socket connection.
+func (s *wsTunnelServer) handleUdpTunnel(w http.ResponseWriter, r *http.Request) {
+	target, err := parseTargetPath(r.URL.Path, UdpPathPrefix)
+	if err != nil {
+		Logger.Errorf("%s - Invalid tunnel path %s: %s", r.RemoteAddr, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	udpConn, err := net.Dial("udp", target)
+	if err != nil {
+		Logger.Errorf("%s - Error while dialing target %s: %s", r.RemoteAddr, target, err)
+		http.Error(w, "Unable to reach tunnel target.", targetDialStatus(err))
+		return
+	}
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Errorf("%s - Upgrade failed: %s", r.RemoteAddr, err)
+		_ = udpConn.Close()
+		return
+	}
+	Logger.Infof("%s - Udp tunnel opened to %s", r.RemoteAddr, target)
+	// Twice the client default so clients normally expire their flows first.
+	b := NewUDPBiDirection(udpConn, wsConn, DefaultUDPIdleTimeout*2)
+	_ = b.Run()
+	Logger.Infof("%s - Udp tunnel closed to %s", r.RemoteAddr, target)
+}
+
+// wantsMux reports whether the client offered the mux subprotocol.
+func wantsMux(r *http.Request) bool {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == MuxSubprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// serveMux accepts streams over a single web socket, streams without target go to the path target.
+func (s *wsTunnelServer) serveMux(w http.ResponseWriter, r *http.Request, defaultTarget string) {
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Errorf("%s - Upgrade failed: %s", r.RemoteAddr, err)
+		return
+	}
+	Logger.Infof("%s - Mux session opened", r.RemoteAddr)
+	session := newMuxSession(wsConn, false, 0, 0)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		go s.handleMuxStream(stream, defaultTarget)
+	}
+	Logger.Infof("%s - Mux session closed", r.RemoteAddr)
+}
+
+// handleMuxStream dials stream target and bridges it.
+func (s *wsTunnelServer) handleMuxStream(stream *muxStream, defaultTarget string) {
+	target := defaultTarget
+	if stream.target != "" {
+		if _, _, err := net.SplitHostPort(stream.target); err != nil {
+			Logger.Errorf("%s - Invalid stream target %s", stream.RemoteAddr(), stream.target)
+			stream.Reject(muxFailRejected)
+			return
+		}
+		target = stream.target
+	}
+	tcpConn, err := net.DialTimeout("tcp", target, s.dialTimeout)
+	if err != nil {
+		Logger.Errorf("%s - Error while dialing target %s: %s", stream.RemoteAddr(), target, err)
+		reason := muxFailUnreachable
+		if targetDialStatus(err) == http.StatusGatewayTimeout {
+			reason = muxFailTimeout
+		}
+		stream.Reject(reason)
+		return
+	}
+	if err = stream.Acknowledge(); err != nil {
+		_ = tcpConn.Close()
+		return
+	}
+	b := NewStunnelBiDirection(tcpConn, stream, s.mtu)
+	_ = b.Run()
+}
+
+// targetDialStatus answers upgrade requests with 504 when the target did not answer in time, 502 otherwise.
+func targetDialStatus(err error) int {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// targetPath builds tunnel path for host:port target, the inverse of parseTargetPath.
+func targetPath(prefix string, target string) string {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return prefix + target
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return prefix + host + "/" + port
+}
+
+// withPath replaces path of remote url.
+func withPath(remote string, path string) (string, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// parseTargetPath extracts host:port from paths like /tcp/127.0.0.1/1194.
+func parseTargetPath(path string, prefix string) (string, error) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", fmt.Errorf("path must start with %s", prefix)
+	}
+	rest := strings.TrimSuffix(strings.TrimPrefix(path, prefix), "/")
+	i := strings.LastIndex(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return "", fmt.Errorf("path must look like %s<host>/<port>", prefix)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(rest[:i], "["), "]")
+	port, err := strconv.Atoi(rest[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port %q", rest[i+1:])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
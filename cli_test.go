@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Windscribe/wstunnel/cli"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain initialises logging once, proxies of earlier tests keep logging while later tests run.
+func TestMain(m *testing.M) {
+	Initialise(false, "")
+	os.Exit(m.Run())
+}
+
+func TestProxiesAreControlledById(t *testing.T) {
+	first := OpenProxy("127.0.0.1:1220", "ws://127.0.0.1:8097/tcp/127.0.0.1/7017", cli.WSTunnel, 1500, false, "")
+	second := OpenProxy("127.0.0.1:1221", "ws://127.0.0.1:8097/tcp/127.0.0.1/7017", cli.WSTunnel, 1500, false, "")
+	if first == 0 || second == 0 || first == second {
+		t.Fatalf("expected two proxy ids, got %d and %d", first, second)
+	}
+	defer StopProxy(second)
+	time.Sleep(time.Millisecond * 100)
+	stopped := lookupProxy(first)
+	StopProxy(first)
+	<-stopped.done
+	if !stopped.succeeded {
+		t.Error("stopped proxy should return without error")
+	}
+	if lookupProxy(first) != nil || WaitProxy(first) {
+		t.Error("stopped proxy should be forgotten")
+	}
+	var status struct {
+		State string `json:"state"`
+	}
+	_ = json.Unmarshal([]byte(GetProxyStatus(second)), &status)
+	if status.State != cli.StateRunning.String() {
+		t.Errorf("stopping first proxy should not affect second, got %s", status.State)
+	}
+	SetTLSFingerprint("not-a-fingerprint")
+	defer SetTLSFingerprint("")
+	if id := OpenProxy("127.0.0.1:1222", "ws://127.0.0.1:8097/tcp/127.0.0.1/7017", cli.WSTunnel, 1500, false, ""); id != 0 {
+		t.Errorf("invalid settings should not start a proxy, got id %d", id)
+	}
+}
+
+func TestStartProxyBlocksUntilStopped(t *testing.T) {
+	result := make(chan bool, 1)
+	go func() {
+		result <- StartProxy("127.0.0.1:1227", "ws://127.0.0.1:8097/tcp/127.0.0.1/7017", cli.WSTunnel, 1500, false, "")
+	}()
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case <-result:
+		t.Fatal("StartProxy should block while the proxy runs")
+	default:
+	}
+	var status struct {
+		State string `json:"state"`
+	}
+	_ = json.Unmarshal([]byte(GetStatus()), &status)
+	if status.State != cli.StateRunning.String() {
+		t.Errorf("exports without id should act on the started proxy, got %s", status.State)
+	}
+	Stop()
+	if !<-result {
+		t.Error("stopped proxy should return true")
+	}
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+	if StartProxy(occupied.Addr().String(), "ws://127.0.0.1:8097/tcp/127.0.0.1/7017", cli.WSTunnel, 1500, false, "") {
+		t.Error("StartProxy should report listen errors")
+	}
+}
+
+func TestSettingsDefaultsAreNotChangedByStarts(t *testing.T) {
+	defaults := defaultSettings()
+	wg := sync.WaitGroup{}
+	ids := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(port int) {
+			defer wg.Done()
+			ids <- OpenProxyWithConfig(fmt.Sprintf(`{"listen": {"address": "127.0.0.1:%d"}, "remotes": ["ws://127.0.0.1:8097/tcp/127.0.0.1/7017"], "tls": {"fingerprint": "firefox"}}`, port))
+		}(1228 + i)
+		go func() {
+			defer wg.Done()
+			SetMultiplexing(false)
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	for id := range ids {
+		if id == 0 {
+			t.Error("proxy should start")
+		}
+		StopProxy(id)
+	}
+	after := defaultSettings()
+	if after.listenAddress != defaults.listenAddress || after.tlsFingerprint != defaults.tlsFingerprint {
+		t.Errorf("started proxies should not change settings defaults, got %s %s", after.listenAddress, after.tlsFingerprint)
+	}
+}
+
+func TestReloadKeepsSettingsWhenRejected(t *testing.T) {
+	id := OpenProxyWithConfig(`{"listen": {"address": "127.0.0.1:1225"}, "remotes": ["ws://127.0.0.1:8097/tcp/127.0.0.1/7017"]}`)
+	if id == 0 {
+		t.Fatal("proxy should start")
+	}
+	defer StopProxy(id)
+	time.Sleep(time.Millisecond * 100)
+	p := lookupProxy(id)
+	if ReloadProxy(id, `{"listen": {"address": "127.0.0.1:1226"}, "remotes": ["ws://127.0.0.1:8097/tcp/127.0.0.1/7017"], "tls": {"fingerprint": "not-a-fingerprint"}}`) {
+		t.Fatal("reload with invalid fingerprint should fail")
+	}
+	if address := p.currentSettings().listenAddress; address != "127.0.0.1:1225" {
+		t.Errorf("rejected reload should keep settings, got listen address %s", address)
+	}
+	if !ReloadProxy(id, `{"listen": {"address": "127.0.0.1:1226"}, "remotes": ["ws://127.0.0.1:8097/tcp/127.0.0.1/7017"]}`) {
+		t.Fatal("valid reload should be applied")
+	}
+	if address := p.currentSettings().listenAddress; address != "127.0.0.1:1226" {
+		t.Errorf("reload should replace settings, got listen address %s", address)
+	}
+}
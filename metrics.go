@@ -0,0 +1,130 @@
+//go:build !nometrics
+
+// Metrics endpoint, included by default. Mobile libraries are built with the nometrics tag and leave it out,
+// build them with WITH_METRICS=1 to include it and the StartMetrics export.
+
+package main
+
+import (
+	"fmt"
+	"github.com/Windscribe/wstunnel/cli"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var metricsAddress string
+
+func init() {
+	rootCmd.Flags().StringVar(&metricsAddress, "metricsAddress", "", "Serve Prometheus /metrics and /debug/pprof on this address > 127.0.0.1:9100. Disabled when empty.")
+	startHooks = append(startHooks, startMetrics)
+}
+
+// startMetrics serves metricsAddress flag and exits when it can not.
+func startMetrics() {
+	if metricsAddress != "" && !StartMetrics(metricsAddress) {
+		os.Exit(1)
+	}
+}
+
+// StartMetrics serves /metrics and /debug/pprof on address until the process exits.
+//
+//export StartMetrics
+func StartMetrics(address string) bool {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		cli.Logger.Errorf("Unable to serve metrics on %s: %s", address, err)
+		return false
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	cli.Logger.Infof("Serving metrics on %s", listener.Addr())
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			cli.Logger.Errorf("Metrics server stopped: %s", err)
+		}
+	}()
+	return true
+}
+
+// serveMetrics writes stats of all started proxies in Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var all []proxyStats
+	for _, p := range allProxies() {
+		all = append(all, proxyStats{id: p.id, stats: p.controller.Stats()})
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, all)
+}
+
+// proxyStats are stats of the proxy with id, samples carry the id as proxy label.
+type proxyStats struct {
+	id    int
+	stats cli.ClientStats
+}
+
+// writeMetrics formats stats, names follow Prometheus conventions with wstunnel_ prefix.
+func writeMetrics(w io.Writer, all []proxyStats) {
+	counters := []struct {
+		name       string
+		metricType string
+		help       string
+		value      func(stats cli.ClientStats) int64
+	}{
+		{"wstunnel_active_connections", "gauge", "Open tunnels.", func(s cli.ClientStats) int64 { return int64(s.ActiveConnections) }},
+		{"wstunnel_connections_total", "counter", "Tunnels opened since start.", func(s cli.ClientStats) int64 { return s.TotalConnections }},
+		{"wstunnel_sent_bytes_total", "counter", "Bytes sent from local connections to remote.", func(s cli.ClientStats) int64 { return s.BytesSent }},
+		{"wstunnel_received_bytes_total", "counter", "Bytes received from remote for local connections.", func(s cli.ClientStats) int64 { return s.BytesReceived }},
+		{"wstunnel_sent_messages_total", "counter", "Web socket messages, datagrams or Stunnel writes sent to remote.", func(s cli.ClientStats) int64 { return s.MessagesSent }},
+		{"wstunnel_received_messages_total", "counter", "Web socket messages, datagrams or Stunnel reads received from remote.", func(s cli.ClientStats) int64 { return s.MessagesReceived }},
+		{"wstunnel_reconnects_total", "counter", "Dial retries after a failed attempt.", func(s cli.ClientStats) int64 { return s.Reconnects }},
+	}
+	for _, counter := range counters {
+		writeFamily(w, counter.name, counter.metricType, counter.help)
+		for _, p := range all {
+			fmt.Fprintf(w, "%s{proxy=\"%d\"} %d\n", counter.name, p.id, counter.value(p.stats))
+		}
+	}
+
+	writeFamily(w, "wstunnel_dial_errors_total", "counter", "Failed dial attempts by reason.")
+	for _, p := range all {
+		reasons := make([]string, 0, len(p.stats.DialErrorsByReason))
+		for reason := range p.stats.DialErrorsByReason {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "wstunnel_dial_errors_total{proxy=\"%d\",reason=\"%s\"} %d\n", p.id, labelEscaper.Replace(reason), p.stats.DialErrorsByReason[reason])
+		}
+	}
+
+	writeFamily(w, "wstunnel_handshake_duration_seconds", "histogram", "Time to connect to remote including tls and web socket handshakes.")
+	for _, p := range all {
+		for _, bucket := range p.stats.HandshakeHistogram {
+			fmt.Fprintf(w, "wstunnel_handshake_duration_seconds_bucket{proxy=\"%d\",le=%q} %d\n", p.id, strconv.FormatFloat(bucket.UpperBound.Seconds(), 'g', -1, 64), bucket.Count)
+		}
+		fmt.Fprintf(w, "wstunnel_handshake_duration_seconds_bucket{proxy=\"%d\",le=\"+Inf\"} %d\n", p.id, p.stats.Handshakes)
+		fmt.Fprintf(w, "wstunnel_handshake_duration_seconds_sum{proxy=\"%d\"} %s\n", p.id, strconv.FormatFloat(p.stats.HandshakeTotal.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "wstunnel_handshake_duration_seconds_count{proxy=\"%d\"} %d\n", p.id, p.stats.Handshakes)
+	}
+}
+
+// labelEscaper escapes label values as the text format expects, Go quoting also escapes other characters.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeFamily writes HELP and TYPE lines once per metric, samples of all proxies follow.
+func writeFamily(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
@@ -0,0 +1,69 @@
+//go:build !nometrics
+
+package main
+
+import (
+	"bytes"
+	"github.com/Windscribe/wstunnel/cli"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var buffer bytes.Buffer
+	writeMetrics(&buffer, []proxyStats{
+		{id: 1, stats: cli.ClientStats{
+			ActiveConnections:  2,
+			TotalConnections:   5,
+			TrafficStats:       cli.TrafficStats{BytesSent: 1024},
+			Handshakes:         3,
+			HandshakeTotal:     time.Millisecond * 1500,
+			HandshakeHistogram: []cli.HistogramBucket{{UpperBound: time.Millisecond * 100, Count: 1}, {UpperBound: time.Second, Count: 2}},
+			DialErrorsByReason: map[string]int64{"timeout": 4, `bad "tls"\` + "\n": 1},
+		}},
+		{id: 2, stats: cli.ClientStats{}},
+	})
+	output := buffer.String()
+	expected := []string{
+		"# HELP wstunnel_active_connections Open tunnels.\n# TYPE wstunnel_active_connections gauge\n",
+		"# TYPE wstunnel_connections_total counter\n",
+		"wstunnel_active_connections{proxy=\"1\"} 2\n",
+		"wstunnel_active_connections{proxy=\"2\"} 0\n",
+		"wstunnel_connections_total{proxy=\"1\"} 5\n",
+		"wstunnel_sent_bytes_total{proxy=\"1\"} 1024\n",
+		"wstunnel_dial_errors_total{proxy=\"1\",reason=\"timeout\"} 4\n",
+		"wstunnel_dial_errors_total{proxy=\"1\",reason=\"bad \\\"tls\\\"\\\\\\n\"} 1\n",
+		"# TYPE wstunnel_handshake_duration_seconds histogram\n",
+		"wstunnel_handshake_duration_seconds_bucket{proxy=\"1\",le=\"0.1\"} 1\n",
+		"wstunnel_handshake_duration_seconds_bucket{proxy=\"1\",le=\"1\"} 2\n",
+		"wstunnel_handshake_duration_seconds_bucket{proxy=\"1\",le=\"+Inf\"} 3\n",
+		"wstunnel_handshake_duration_seconds_sum{proxy=\"1\"} 1.5\n",
+		"wstunnel_handshake_duration_seconds_count{proxy=\"1\"} 3\n",
+		"wstunnel_handshake_duration_seconds_bucket{proxy=\"2\",le=\"+Inf\"} 0\n",
+		"wstunnel_handshake_duration_seconds_count{proxy=\"2\"} 0\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("metrics should contain %q", line)
+		}
+	}
+	families := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if families[name] {
+				t.Errorf("family %s should have a single TYPE line", name)
+			}
+			families[name] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(line[:strings.IndexAny(line, "{ ")], "_bucket"), "_sum"), "_count")
+		if !strings.HasPrefix(name, "wstunnel_") || !families[name] {
+			t.Errorf("sample %q should follow TYPE line of its wstunnel_ family", line)
+		}
+	}
+}
@@ -78,6 +78,16 @@ type Dialer struct {
 	// is done there and TLSClientConfig is ignored.
 	TLSClientConfig *tls.Config
 
+	// VerifyConnection, if not nil, is called after the TLS handshake with the
+	// negotiated connection state. If it returns an error the dial is aborted
+	// with that error. It is called even if InsecureSkipVerify is set.
+	VerifyConnection func(state tls.ConnectionState) error
+
+	// ClientHelloSpec, if not nil, returns the uTLS ClientHello specification
+	// applied to the TLS handshake. It is called once per dial. If nil, a
+	// randomized ClientHello without ALPN is used.
+	ClientHelloSpec func() (*tls.ClientHelloSpec, error)
+
 	// HandshakeTimeout specifies the duration for the handshake to complete.
 	HandshakeTimeout time.Duration
 
@@ -346,13 +356,25 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		if cfg.ServerName == "" {
 			cfg.ServerName = hostNoPort
 		}
-		tlsConn := tls.UClient(netConn, cfg, tls.HelloRandomizedNoALPN)
+		var tlsConn *tls.UConn
+		if d.ClientHelloSpec != nil {
+			spec, err := d.ClientHelloSpec()
+			if err != nil {
+				return nil, nil, err
+			}
+			tlsConn = tls.UClient(netConn, cfg, tls.HelloCustom)
+			if err := tlsConn.ApplyPreset(spec); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			tlsConn = tls.UClient(netConn, cfg, tls.HelloRandomizedNoALPN)
+		}
 		netConn = tlsConn
 
 		if trace != nil && trace.TLSHandshakeStart != nil {
 			trace.TLSHandshakeStart()
 		}
-		err := doHandshake(ctx, tlsConn, cfg)
+		err := doHandshake(ctx, tlsConn, cfg, d.VerifyConnection)
 		//if trace != nil && trace.TLSHandshakeDone != nil {
 		//	trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
 		//}
@@ -8,10 +8,15 @@ import (
 )
 import tls "github.com/refraction-networking/utls"
 
-func doHandshake(ctx context.Context, tlsConn *tls.UConn, cfg *tls.Config) error {
+func doHandshake(ctx context.Context, tlsConn *tls.UConn, cfg *tls.Config, verify func(tls.ConnectionState) error) error {
 	if err := tlsConn.HandshakeContext(ctx); err != nil {
 		return err
 	}
+	if verify != nil {
+		if err := verify(tlsConn.ConnectionState()); err != nil {
+			return err
+		}
+	}
 	if !cfg.InsecureSkipVerify {
 		if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
 			return err
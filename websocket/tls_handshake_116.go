@@ -8,10 +8,15 @@ import (
 	"crypto/tls"
 )
 
-func doHandshake(ctx context.Context, tlsConn *tls.Conn, cfg *tls.Config) error {
+func doHandshake(ctx context.Context, tlsConn *tls.Conn, cfg *tls.Config, verify func(tls.ConnectionState) error) error {
 	if err := tlsConn.Handshake(); err != nil {
 		return err
 	}
+	if verify != nil {
+		if err := verify(tlsConn.ConnectionState()); err != nil {
+			return err
+		}
+	}
 	if !cfg.InsecureSkipVerify {
 		if err := tlsConn.VerifyHostname(cfg.ServerName); err != nil {
 			return err